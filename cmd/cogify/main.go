@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	cog "github.com/flywave/go-cog"
+	"github.com/flywave/go-geo"
+
+	vec2d "github.com/flywave/go3d/float64/vec2"
+)
+
+var compressions = map[string]cog.CompressionType{
+	"none":    cog.CTNone,
+	"lzw":     cog.CTLZW,
+	"deflate": cog.CTDeflate,
+}
+
+var predictors = map[string]cog.Predictor{
+	"none":       cog.PredictorNone,
+	"horizontal": cog.PredictorHorizontal,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: cogify [flags] <input.tif|-> <output.tif|->\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	tileSize := flag.Int("tile", 512, "tile size in pixels")
+	compression := flag.String("compression", "lzw", "compression: none, lzw, deflate")
+	predictor := flag.String("predictor", "none", "predictor: none, horizontal")
+	levels := flag.Int("overviews", -1, "number of overview levels, -1 to stop once the image fits in a single tile")
+	resampling := flag.String("resampling", "bilinear", "resampling: nearest, bilinear, cubic")
+	bigtiff := flag.Bool("bigtiff", false, "write BigTIFF")
+	sparse := flag.Bool("sparse", false, "omit tiles that hold only nodata")
+	nodata := flag.String("nodata", "", "nodata value, defaults to the input nodata")
+	grid := flag.String("grid", "", "target grid SRS (e.g. EPSG:3857), defaults to the input SRS")
+	srs := flag.String("srs", "", "input SRS (e.g. EPSG:3857), overrides the SRS of the input file")
+	spool := flag.String("spool", "file", "tile spool: file, memory, none (encode twice, no buffering)")
+	stats := flag.String("stats", "none", "band statistics written to the metadata: none, exact, approx")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	err := run(flag.Arg(0), flag.Arg(1), config{
+		tileSize:    *tileSize,
+		compression: *compression,
+		predictor:   *predictor,
+		levels:      *levels,
+		resampling:  *resampling,
+		bigtiff:     *bigtiff,
+		sparse:      *sparse,
+		nodata:      *nodata,
+		grid:        *grid,
+		srs:         *srs,
+		spool:       *spool,
+		stats:       *stats,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cogify:", err)
+		os.Exit(1)
+	}
+}
+
+type config struct {
+	tileSize    int
+	compression string
+	predictor   string
+	levels      int
+	resampling  string
+	bigtiff     bool
+	sparse      bool
+	nodata      string
+	grid        string
+	srs         string
+	spool       string
+	stats       string
+}
+
+func run(input, output string, c config) error {
+	ctype, ok := compressions[strings.ToLower(c.compression)]
+	if !ok {
+		return fmt.Errorf("unknown compression %q", c.compression)
+	}
+	pred, ok := predictors[strings.ToLower(c.predictor)]
+	if !ok {
+		return fmt.Errorf("unknown predictor %q", c.predictor)
+	}
+	method, ok := cog.ResampleMethodMap[strings.ToLower(c.resampling)]
+	if !ok {
+		return fmt.Errorf("unknown resampling %q", c.resampling)
+	}
+	if c.tileSize <= 0 || c.tileSize%16 != 0 {
+		return fmt.Errorf("tile size must be a positive multiple of 16")
+	}
+
+	r, err := readInput(input)
+	if err != nil {
+		return err
+	}
+
+	w, err := newWarper(r, c.srs, method)
+	if err != nil {
+		return err
+	}
+	noData := r.GetNoData(0)
+	if c.nodata != "" {
+		v, err := strconv.ParseFloat(c.nodata, 64)
+		if err != nil {
+			return fmt.Errorf("invalid nodata %q", c.nodata)
+		}
+		noData = &v
+		w.SetNoData(noData)
+	}
+
+	srs, err := targetSrs(r, c.grid, c.srs)
+	if err != nil {
+		return err
+	}
+
+	conf := geo.DefaultTileGridOptions()
+	conf[geo.TILEGRID_SRS] = srs
+	conf[geo.TILEGRID_RES_FACTOR] = 2.0
+	conf[geo.TILEGRID_TILE_SIZE] = []uint32{uint32(c.tileSize), uint32(c.tileSize)}
+	conf[geo.TILEGRID_ORIGIN] = geo.ORIGIN_UL
+	tg := geo.NewTileGrid(conf)
+
+	ext := w.Extent(srs)
+	size := r.GetSize(0)
+	res := math.Min((ext.Max[0]-ext.Min[0])/float64(size[0]), (ext.Max[1]-ext.Min[1])/float64(size[1]))
+	if !(res > 0) {
+		return fmt.Errorf("cannot compute input resolution in %s", srs.GetSrsCode())
+	}
+
+	lvls := overviewLevels(tg, ext, tg.ClosestLevel(res), c.levels, c.tileSize)
+	layers := cog.BuildTileLayers(ext, lvls, tg)
+	for i, l := range layers {
+		l.SetNoData(noData)
+		if i > 0 {
+			w = cog.NewLayerWarper(layers[i-1], method)
+		}
+		if err := w.WarpLayer(l, ctype); err != nil {
+			return err
+		}
+	}
+
+	opts := &cog.Options{
+		BigTiff:     c.bigtiff,
+		Sparse:      c.sparse,
+		Compression: ctype,
+		Predictor:   pred,
+		Tags:        r.GetTags(0),
+	}
+	if md, err := r.GetMetadata(0); err == nil {
+		opts.GDALMetadata = md
+	}
+	opts.NoData = noData
+	switch c.stats {
+	case "exact":
+		opts.Statistics = true
+	case "approx":
+		opts.ApproxStatistics = true
+	case "none":
+	default:
+		return fmt.Errorf("unknown stats %q", c.stats)
+	}
+	switch c.spool {
+	case "file":
+		opts.Spool = cog.FileSpool(os.TempDir())
+	case "memory":
+		opts.Spool = cog.MemorySpool()
+	case "none":
+	default:
+		return fmt.Errorf("unknown spool %q", c.spool)
+	}
+
+	if output == "-" {
+		return cog.WriteTo(os.Stdout, layers, opts)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	err = cog.WriteTo(f, layers, opts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// overviewLevels lists top and the levels below it, stopping after levels
+// overviews or, when levels is negative, once the previous level's extent
+// fits in a single tile.
+func overviewLevels(tg *geo.TileGrid, ext vec2d.Rect, top, levels, tileSize int) []int {
+	lvls := []int{top}
+	for l := top - 1; l >= 0; l-- {
+		if levels >= 0 && len(lvls) > levels {
+			break
+		}
+		if levels < 0 {
+			prev := tg.Resolution(l + 1)
+			width := int(math.Round((ext.Max[0] - ext.Min[0]) / prev))
+			height := int(math.Round((ext.Max[1] - ext.Min[1]) / prev))
+			if width <= tileSize && height <= tileSize {
+				break
+			}
+		}
+		lvls = append(lvls, l)
+	}
+	return lvls
+}
+
+func newWarper(r *cog.Reader, srs string, method cog.ResampleMethod) (*cog.Warper, error) {
+	if srs == "" {
+		return cog.NewReaderWarper(r, 0, method)
+	}
+	tr, err := r.GetPixelTransformer(0)
+	if err != nil {
+		return nil, err
+	}
+	size := r.GetSize(0)
+	src := cog.NewMemorySource(r.Data[0], image.Rect(0, 0, int(size[0]), int(size[1])))
+	w := cog.NewWarper(src, tr, geo.NewProj(srs), method)
+	w.SetNoData(r.GetNoData(0))
+	return w, nil
+}
+
+func targetSrs(r *cog.Reader, grid, srs string) (geo.Proj, error) {
+	if grid != "" {
+		return geo.NewProj(grid), nil
+	}
+	if srs != "" {
+		return geo.NewProj(srs), nil
+	}
+	code, err := r.GetEPSGCode(0)
+	if err != nil {
+		return nil, err
+	}
+	if code == 0 {
+		return geo.NewProj(4326), nil
+	}
+	return geo.NewProj(code), nil
+}
+
+func readInput(input string) (r *cog.Reader, err error) {
+	var data []byte
+	if input == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(input)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("read %s: %v", input, p)
+		}
+	}()
+	r = cog.ReadTiff(bytes.NewReader(data))
+	if r == nil || len(r.Data) == 0 || r.Data[0] == nil {
+		return nil, fmt.Errorf("read %s: unsupported tiff", input)
+	}
+	return r, nil
+}
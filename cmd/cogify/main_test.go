@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cog "github.com/flywave/go-cog"
+	"github.com/flywave/go-geo"
+
+	vec2d "github.com/flywave/go3d/float64/vec2"
+)
+
+func TestConvertStrippedTiff(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.tif")
+	err := run("../../test_data/test.tif", out, config{
+		tileSize:    256,
+		compression: "lzw",
+		predictor:   "horizontal",
+		levels:      -1,
+		resampling:  "nearest",
+		srs:         "EPSG:3857",
+		spool:       "memory",
+		stats:       "none",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	report, err := cog.Validate(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("output is not a valid COG: %v", report.Errors)
+	}
+
+	r := cog.Read(out)
+	if code, err := r.GetEPSGCode(0); err != nil || code != 3857 {
+		t.Fatalf("got EPSG %d %v", code, err)
+	}
+	if r.Data[0].Type != cog.DTUInt32 {
+		t.Fatalf("read back %s", r.Data[0].Type)
+	}
+}
+
+func TestOverviewLevels(t *testing.T) {
+	conf := geo.DefaultTileGridOptions()
+	conf[geo.TILEGRID_SRS] = geo.NewProj(900913)
+	conf[geo.TILEGRID_TILE_SIZE] = []uint32{256, 256}
+	tg := geo.NewTileGrid(conf)
+
+	res := tg.Resolution(10)
+	ext := vec2d.Rect{Max: vec2d.T{1024 * res * (1 + 1e-12), 512 * res * (1 + 1e-12)}}
+	lvls := overviewLevels(tg, ext, 10, -1, 256)
+	if len(lvls) != 3 || lvls[2] != 8 {
+		t.Fatalf("got levels %v", lvls)
+	}
+}
+
+func TestConvertRPC(t *testing.T) {
+	m := &cog.RPCModel{
+		LineOff: 50, SampOff: 50, LatOff: 30, LongOff: 120, HeightOff: 0,
+		LineScale: 50, SampScale: 50, LatScale: 0.01, LongScale: 0.01, HeightScale: 500,
+	}
+	m.LineNum[2] = -1
+	m.LineDen[0] = 1
+	m.SampNum[1] = 1
+	m.SampDen[0] = 1
+
+	data := make([]uint16, 100*100)
+	for i := range data {
+		data[i] = uint16(i%100 + 1)
+	}
+	rect := image.Rect(0, 0, 100, 100)
+	in := filepath.Join(t.TempDir(), "rpc.tif")
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := cog.NewTileWriter(cog.NewSource(data, &rect, cog.CTNone), binary.LittleEndian, false, vec2d.Rect{}, nil, [2]uint32{100, 100}, nil)
+	w.SetRPC(m)
+	err = w.WriteData(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, err := cog.Read(in).GetEPSGCode(0); err != nil || code != 0 {
+		t.Fatalf("input has EPSG %d %v", code, err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out.tif")
+	err = run(in, out, config{
+		tileSize:    256,
+		compression: "lzw",
+		predictor:   "none",
+		levels:      -1,
+		resampling:  "bilinear",
+		spool:       "memory",
+		stats:       "none",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cog.Read(out)
+	if code, err := r.GetEPSGCode(0); err != nil || code != 4326 {
+		t.Fatalf("got EPSG %d %v", code, err)
+	}
+}
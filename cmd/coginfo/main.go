@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	cog "github.com/flywave/go-cog"
+	"github.com/google/tiff"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: coginfo [-json] <file.tif|->\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	asJSON := flag.Bool("json", false, "print JSON using gdalinfo -json field names where possible")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *asJSON, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "coginfo:", err)
+		os.Exit(1)
+	}
+}
+
+func open(name string) (tiff.ReadAtReadSeeker, func() error, error) {
+	if name == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bytes.NewReader(data), func() error { return nil }, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+func run(name string, asJSON bool, out io.Writer) error {
+	r, closer, err := open(name)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	info, err := cog.ReadInfo(r)
+	if err != nil {
+		return err
+	}
+	info.Description = name
+
+	if asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	printInfo(out, info)
+	return nil
+}
+
+func sortedDomains(m map[string]map[string]string) []string {
+	domains := make([]string, 0, len(m))
+	for d := range m {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printInfo(w io.Writer, info *cog.Info) {
+	fmt.Fprintf(w, "Driver: %s/%s\n", info.DriverShortName, info.DriverLongName)
+	fmt.Fprintf(w, "Files: %s\n", info.Description)
+	fmt.Fprintf(w, "Size is %d, %d\n", info.Size[0], info.Size[1])
+
+	if len(info.GeoKeys) > 0 {
+		fmt.Fprintln(w, "GeoKeys:")
+		keys := make([]string, 0, len(info.GeoKeys))
+		for k := range info.GeoKeys {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "  %s = %v\n", k, info.GeoKeys[k])
+		}
+	}
+
+	if gt := info.GeoTransform; len(gt) == 6 {
+		if gt[2] == 0 && gt[4] == 0 {
+			fmt.Fprintf(w, "Origin = (%.15f,%.15f)\n", gt[0], gt[3])
+			fmt.Fprintf(w, "Pixel Size = (%.15f,%.15f)\n", gt[1], gt[5])
+		} else {
+			fmt.Fprintf(w, "GeoTransform =\n  %.16g, %.16g, %.16g\n  %.16g, %.16g, %.16g\n", gt[0], gt[1], gt[2], gt[3], gt[4], gt[5])
+		}
+	}
+
+	for _, d := range sortedDomains(info.Metadata) {
+		if d == "" {
+			fmt.Fprintln(w, "Metadata:")
+		} else {
+			fmt.Fprintf(w, "%s Metadata:\n", d)
+		}
+		for _, k := range sortedKeys(info.Metadata[d]) {
+			fmt.Fprintf(w, "  %s=%s\n", k, info.Metadata[d][k])
+		}
+	}
+
+	if len(info.StructuralMetadata) > 0 {
+		fmt.Fprintln(w, "Structural Metadata:")
+		for _, k := range sortedKeys(info.StructuralMetadata) {
+			fmt.Fprintf(w, "  %s=%s\n", k, info.StructuralMetadata[k])
+		}
+	}
+
+	if c := info.CornerCoordinates; c != nil {
+		fmt.Fprintln(w, "Corner Coordinates:")
+		fmt.Fprintf(w, "Upper Left  (%14.6f, %14.6f)\n", c.UpperLeft[0], c.UpperLeft[1])
+		fmt.Fprintf(w, "Lower Left  (%14.6f, %14.6f)\n", c.LowerLeft[0], c.LowerLeft[1])
+		fmt.Fprintf(w, "Upper Right (%14.6f, %14.6f)\n", c.UpperRight[0], c.UpperRight[1])
+		fmt.Fprintf(w, "Lower Right (%14.6f, %14.6f)\n", c.LowerRight[0], c.LowerRight[1])
+		fmt.Fprintf(w, "Center      (%14.6f, %14.6f)\n", c.Center[0], c.Center[1])
+	}
+
+	for _, b := range info.Bands {
+		fmt.Fprintf(w, "Band %d Block=%dx%d Type=%s, ColorInterp=%s\n", b.Band, b.Block[0], b.Block[1], b.Type, b.ColorInterpretation)
+		if b.Description != "" {
+			fmt.Fprintf(w, "  Description = %s\n", b.Description)
+		}
+		if b.Minimum != nil && b.Maximum != nil && b.Mean != nil && b.StdDev != nil {
+			fmt.Fprintf(w, "  Minimum=%.3f, Maximum=%.3f, Mean=%.3f, StdDev=%.3f\n", *b.Minimum, *b.Maximum, *b.Mean, *b.StdDev)
+		}
+		if b.NoDataValue != nil {
+			fmt.Fprintf(w, "  NoData Value=%v\n", b.NoDataValue)
+		}
+		if b.Offset != nil || b.Scale != nil {
+			offset, scale := 0.0, 1.0
+			if b.Offset != nil {
+				offset = *b.Offset
+			}
+			if b.Scale != nil {
+				scale = *b.Scale
+			}
+			fmt.Fprintf(w, "  Offset: %g,   Scale:%g\n", offset, scale)
+		}
+		if b.Unit != "" {
+			fmt.Fprintf(w, "  Unit Type: %s\n", b.Unit)
+		}
+		for _, d := range sortedDomains(b.Metadata) {
+			if d == "" {
+				fmt.Fprintln(w, "  Metadata:")
+			} else {
+				fmt.Fprintf(w, "  %s Metadata:\n", d)
+			}
+			for _, k := range sortedKeys(b.Metadata[d]) {
+				fmt.Fprintf(w, "    %s=%s\n", k, b.Metadata[d][k])
+			}
+		}
+		if len(b.Overviews) > 0 {
+			sizes := make([]string, len(b.Overviews))
+			for i, o := range b.Overviews {
+				sizes[i] = fmt.Sprintf("%dx%d", o.Size[0], o.Size[1])
+			}
+			fmt.Fprintf(w, "  Overviews: %s\n", strings.Join(sizes, ", "))
+		}
+	}
+
+	for _, ifd := range info.IFDs {
+		kind := "image"
+		switch {
+		case ifd.Mask:
+			kind = "mask"
+		case ifd.Overview:
+			kind = "overview"
+		}
+		fmt.Fprintf(w, "IFD %d (%s): %dx%d", ifd.Index, kind, ifd.Size[0], ifd.Size[1])
+		if ifd.Tiled {
+			fmt.Fprintf(w, ", tiles %dx%d (%dx%d)", ifd.Block[0], ifd.Block[1], ifd.Blocks[0], ifd.Blocks[1])
+		} else {
+			fmt.Fprintf(w, ", strips of %d rows (%d)", ifd.Block[1], ifd.Blocks[1])
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "  Compression=%s Photometric=%s", ifd.Compression, ifd.Photometric)
+		if ifd.Predictor != "" {
+			fmt.Fprintf(w, " Predictor=%s", ifd.Predictor)
+		}
+		if ifd.PlanarConfiguration != "" {
+			fmt.Fprintf(w, " Planar=%s", ifd.PlanarConfiguration)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "  SamplesPerPixel=%d BitsPerSample=%v", ifd.SamplesPerPixel, ifd.BitsPerSample)
+		if len(ifd.SampleFormat) > 0 {
+			fmt.Fprintf(w, " SampleFormat=%s", strings.Join(ifd.SampleFormat, ", "))
+		}
+		fmt.Fprintln(w)
+		if ifd.NoData != "" {
+			fmt.Fprintf(w, "  NoData=%s\n", ifd.NoData)
+		}
+	}
+}
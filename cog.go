@@ -10,24 +10,46 @@ import (
 type CogWriter struct {
 	Writer
 	tiles []*TileLayer
+	spool SpoolFactory
+	opts  *Options
 }
 
-func Write(fileName string, tiles []*TileLayer, bigtiff bool) error {
-	w := &CogWriter{tiles: tiles, Writer: Writer{bigtiff: bigtiff, enc: tiffByteOrder}}
-
-	defer w.Close()
+func NewCogWriter(tiles []*TileLayer, opts *Options) *CogWriter {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &CogWriter{tiles: tiles, spool: opts.Spool, opts: opts, Writer: Writer{bigtiff: opts.BigTiff, enc: opts.byteOrder()}}
+}
 
+func Write(fileName string, tiles []*TileLayer, bigtiff bool) error {
 	f, err := os.Create(fileName)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer f.Close()
 
-	if err := w.writeData(f); err != nil {
-		return err
+	err = WriteTo(f, tiles, &Options{BigTiff: bigtiff, Spool: FileSpool(os.TempDir())})
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
+	return err
+}
 
-	return nil
+func WriteTo(out io.Writer, tiles []*TileLayer, opts *Options) error {
+	w := NewCogWriter(tiles, opts)
+
+	defer w.Close()
+
+	return w.WriteData(out)
+}
+
+func (g *CogWriter) WriteData(out io.Writer) error {
+	if err := g.opts.checkTags(); err != nil {
+		return err
+	}
+	for _, l := range g.tiles {
+		g.opts.applyLayer(l)
+	}
+	return g.writeData(out)
 }
 
 func (g *CogWriter) Close() error {
@@ -40,7 +62,38 @@ func (g *CogWriter) Close() error {
 func (g *CogWriter) writeData(out io.Writer) error {
 	sort.Sort(layerSorted(g.tiles))
 
-	err := g.computeImageryOffsets()
+	var stats []Statistics
+	if l := g.opts.statisticsLayer(g.tiles); l != nil {
+		var err error
+		if stats, err = l.ComputeStatistics(0); err != nil {
+			return fmt.Errorf("compute statistics: %w", err)
+		}
+		for b := range stats {
+			stats[b].Approximate = l != g.tiles[0]
+		}
+	}
+
+	err := g.encodeLayers()
+	if err != nil {
+		return err
+	}
+
+	for i, t := range g.tiles {
+		if i == 0 {
+			if err := g.opts.applyIFD(t.ifd); err != nil {
+				return err
+			}
+			if stats != nil {
+				if err := t.ifd.SetStatistics(stats); err != nil {
+					return err
+				}
+			}
+		} else {
+			t.ifd.NewSubfileType = subfileReduced
+		}
+	}
+
+	err = g.computeImageryOffsets()
 	if err != nil {
 		return err
 	}
@@ -50,13 +103,13 @@ func (g *CogWriter) writeData(out io.Writer) error {
 		strileData.Offset = 8
 	}
 
-	strileData.Offset += uint64(len(ghost))
+	strileData.Offset += uint64(ghostSize)
 
 	for _, t := range g.tiles {
 		strileData.Offset += t.ifd.tagsSize
 	}
 
-	glen := uint64(len(ghost))
+	glen := uint64(ghostSize)
 	g.writeHeader(out)
 
 	off := uint64(16 + glen)
@@ -77,9 +130,16 @@ func (g *CogWriter) writeData(out io.Writer) error {
 		return fmt.Errorf("write strile pointers: %w", err)
 	}
 
-	datas := g.tiles
-	tiles := getTiles(datas)
+	if g.spool == nil {
+		for i := len(g.tiles) - 1; i >= 0; i-- {
+			if err = g.writeLayer(out, g.tiles[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
+	tiles := getTiles(g.tiles)
 	var data []byte
 	for tile := range tiles {
 		idx := (tile.x + tile.y*uint64(tile.layer.col))
@@ -92,7 +152,7 @@ func (g *CogWriter) writeData(out io.Writer) error {
 			if uint32(len(data)) < bc+8 {
 				data = make([]byte, (bc+8)*2)
 			}
-			_, err = tile.layer.GetReader().Read(data[0 : 8+bc])
+			_, err = io.ReadFull(tile.layer.GetReader(), data[0:8+bc])
 			if err != nil {
 				return err
 			}
@@ -106,6 +166,23 @@ func (g *CogWriter) writeData(out io.Writer) error {
 	return err
 }
 
+func (g *CogWriter) writeLayer(out io.Writer, l *TileLayer) error {
+	return encodeTiles(l.tiles, l.concurrency(), l.skipTile, func(i int, r *encodeResult) error {
+		if r.n != l.ifd.TileByteCounts[i] {
+			return fmt.Errorf("tile %v encoded to %d bytes, expected %d", l.tiles[i].Id, r.n, l.ifd.TileByteCounts[i])
+		}
+		if r.n > 0 {
+			if err := writeBlock(out, g.enc, r.buf.Bytes()); err != nil {
+				return err
+			}
+		}
+		if l.tiles[i].Src != nil {
+			l.tiles[i].Src.Reset()
+		}
+		return nil
+	})
+}
+
 func (g *CogWriter) computeStructure() {
 	for _, t := range g.tiles {
 		ifd := t.ifd
@@ -113,12 +190,18 @@ func (g *CogWriter) computeStructure() {
 	}
 }
 
-func (g *CogWriter) computeImageryOffsets() error {
+func (g *CogWriter) encodeLayers() error {
 	for _, t := range g.tiles {
-		err := t.encode(g.enc, true)
+		err := t.encode(g.enc, g.spool, true)
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+func (g *CogWriter) computeImageryOffsets() error {
+	for _, t := range g.tiles {
 		ifd := t.ifd
 		if g.bigtiff {
 			ifd.NewTileOffsets64 = make([]uint64, len(ifd.OriginalTileOffsets))
@@ -135,7 +218,7 @@ func (g *CogWriter) computeImageryOffsets() error {
 		dataOffset = 8
 	}
 
-	dataOffset += uint64(len(ghost)) + 4
+	dataOffset += uint64(ghostSize) + 4
 
 	for _, t := range g.tiles {
 		dataOffset += t.ifd.strileSize + t.ifd.tagsSize
@@ -182,7 +265,8 @@ func getTiles(d []*TileLayer) chan tiledTiff {
 	ch := make(chan tiledTiff)
 	go func() {
 		defer close(ch)
-		for _, l := range d {
+		for i := len(d) - 1; i >= 0; i-- {
+			l := d[i]
 			for _, tile := range l.tiles {
 				ch <- tiledTiff{
 					tile:  tile,
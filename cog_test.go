@@ -1,13 +1,20 @@
 package cog
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"image"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/flywave/go-geo"
 
 	vec2d "github.com/flywave/go3d/float64/vec2"
+	"github.com/google/tiff"
 )
 
 func TestCases(t *testing.T) {
@@ -68,3 +75,271 @@ func TestCases(t *testing.T) {
 	}
 
 }
+
+func TestFlippedGridRoundTrip(t *testing.T) {
+	for _, origin := range []geo.OriginType{geo.ORIGIN_UL, geo.ORIGIN_LL} {
+		conf := geo.DefaultTileGridOptions()
+		conf[geo.TILEGRID_SRS] = geo.NewProj(900913)
+		conf[geo.TILEGRID_TILE_SIZE] = []uint32{16, 16}
+		conf[geo.TILEGRID_ORIGIN] = origin
+		grid := geo.NewTileGrid(conf)
+
+		nw := grid.TileBBox([3]int{8, 8, 4}, false)
+		se := grid.TileBBox([3]int{9, 9, 4}, false)
+		bbox := nw
+		bbox.Join(&se)
+
+		layer := NewTileLayer(bbox, 4, grid)
+		rect := image.Rect(0, 0, 16, 16)
+		for _, tile := range layer.tiles {
+			tb := grid.TileBBox(tile.Id, false)
+			data := make([]uint16, 16*16)
+			for i := range data {
+				if tb.Min[0] < bbox.Min[0]+1 && tb.Max[1] > bbox.Max[1]-1 {
+					data[i] = 1
+				}
+			}
+			layer.SetSource(tile.Id, NewSource(data, &rect, CTLZW))
+		}
+
+		name := filepath.Join(t.TempDir(), "grid.tif")
+		if err := Write(name, []*TileLayer{layer}, false); err != nil {
+			t.Fatal(err)
+		}
+
+		r := Read(name)
+		gt := r.GetGeoTransform(0)
+		if math.Abs(gt[0]-bbox.Min[0]) > 1e-6 || math.Abs(gt[3]-bbox.Max[1]) > 1e-6 || gt[5] >= 0 {
+			t.Fatalf("origin %v: unexpected geotransform %v", origin, gt)
+		}
+		d, _ := r.Data[0].Data()
+		data := d.([]uint16)
+		if data[0] != 1 || data[len(data)-1] != 0 {
+			t.Fatalf("origin %v: north-west tile not at image origin", origin)
+		}
+	}
+}
+
+func TestGridNativeEPSG(t *testing.T) {
+	for _, c := range []struct{ srs, epsg int }{{900913, 3857}, {4326, 4326}} {
+		conf := geo.DefaultTileGridOptions()
+		conf[geo.TILEGRID_SRS] = geo.NewProj(c.srs)
+		conf[geo.TILEGRID_TILE_SIZE] = []uint32{16, 16}
+		grid := geo.NewTileGrid(conf)
+
+		bbox := grid.TileBBox([3]int{8, 8, 4}, false)
+		layer := NewTileLayer(bbox, 4, grid)
+		rect := image.Rect(0, 0, 16, 16)
+		for _, tile := range layer.tiles {
+			layer.SetSource(tile.Id, NewSource(make([]uint16, 16*16), &rect, CTNone))
+		}
+
+		name := filepath.Join(t.TempDir(), "grid.tif")
+		if err := Write(name, []*TileLayer{layer}, false); err != nil {
+			t.Fatal(err)
+		}
+		r := Read(name)
+		if code, err := r.GetEPSGCode(0); err != nil || code != c.epsg {
+			t.Fatalf("srs %d: got EPSG %d %v", c.srs, code, err)
+		}
+		gt := r.GetGeoTransform(0)
+		if math.Abs(gt[0]-bbox.Min[0]) > 1e-6 || math.Abs(gt[3]-bbox.Max[1]) > 1e-6 {
+			t.Fatalf("srs %d: geotransform %v not in grid coordinates", c.srs, gt)
+		}
+	}
+}
+
+func buildTestLayer(t *testing.T) *TileLayer {
+	conf := geo.DefaultTileGridOptions()
+	conf[geo.TILEGRID_SRS] = geo.NewProj(900913)
+	conf[geo.TILEGRID_RES_FACTOR] = 2.0
+	conf[geo.TILEGRID_TILE_SIZE] = []uint32{512, 512}
+	conf[geo.TILEGRID_ORIGIN] = geo.ORIGIN_UL
+	grid := geo.NewTileGrid(conf)
+
+	rect := image.Rect(0, 0, 512, 512)
+	bbox := vec2d.Rect{Min: vec2d.MaxVal, Max: vec2d.MinVal}
+	srcs := make(map[[3]int]TileSource)
+	for _, id := range [][3]int{{13733, 6366, 14}, {13733, 6367, 14}, {13734, 6366, 14}, {13734, 6367, 14}} {
+		gtiff := Read(fmt.Sprintf("./test_data/%d_%d_%d.tif", id[2], id[0], id[1]))
+		srcs[id] = NewSource(gtiff.Data[0], &rect, CTLZW)
+		bb := grid.TileBBox(id, false)
+		bbox.Join(&bb)
+	}
+	layer := NewTileLayer(bbox, 14, grid)
+	for id, src := range srcs {
+		if err := layer.SetSource(id, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return layer
+}
+
+func TestWriteStream(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cog.tif")
+	if err := Write(name, []*TileLayer{buildTestLayer(t)}, false); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, spool := range []SpoolFactory{nil, MemorySpool()} {
+		layer := buildTestLayer(t)
+		buf := &bytes.Buffer{}
+		if err := WriteTo(buf, []*TileLayer{layer}, &Options{Spool: spool}); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Fatal("streamed output differs from file output")
+		}
+		if layer.spool != nil {
+			t.Fatal("spool not released")
+		}
+	}
+
+	r := ReadFrom(bytes.NewReader(expected))
+	if r == nil || r.Data[0] == nil {
+		t.FailNow()
+	}
+}
+
+func TestConcurrentEncodeDeterministic(t *testing.T) {
+	var outputs [][]byte
+	for _, workers := range []int{1, 8} {
+		layer := buildTestLayer(t)
+		layer.SetConcurrency(workers)
+		buf := &bytes.Buffer{}
+		if err := WriteTo(buf, []*TileLayer{layer}, &Options{Spool: MemorySpool()}); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, buf.Bytes())
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Fatal("output depends on encoder concurrency")
+	}
+}
+
+func TestWriteToOptions(t *testing.T) {
+	buf := &bytes.Buffer{}
+	opts := &Options{Compression: CTDeflate, Metadata: map[string]string{"AREA": "a<b"}}
+	if err := WriteTo(buf, []*TileLayer{buildTestLayer(t)}, opts); err != nil {
+		t.Fatal(err)
+	}
+	r := ReadFrom(bytes.NewReader(buf.Bytes()))
+	if r == nil || r.Data[0] == nil {
+		t.FailNow()
+	}
+	if r.ifds[0].Compression != CTDeflate {
+		t.Fatalf("compression %d", r.ifds[0].Compression)
+	}
+	if r.ifds[0].GDALMetaData != `<GDALMetadata><Item name="AREA">a&lt;b</Item></GDALMetadata>` {
+		t.Fatalf("metadata %q", r.ifds[0].GDALMetaData)
+	}
+
+	if err := Write(filepath.Join(t.TempDir(), "missing", "cog.tif"), []*TileLayer{buildTestLayer(t)}, false); err == nil {
+		t.Fatal("expected error for unwritable path")
+	}
+}
+
+func TestBlockLeaderTrailer(t *testing.T) {
+	for _, ctype := range []CompressionType{CTNone, CTLZW} {
+		for _, spool := range []SpoolFactory{nil, MemorySpool()} {
+			buf := &bytes.Buffer{}
+			opts := &Options{Compression: ctype, Spool: spool}
+			if err := WriteTo(buf, []*TileLayer{buildTestLayer(t)}, opts); err != nil {
+				t.Fatal(err)
+			}
+			data := buf.Bytes()
+			if err := VerifyBlocks(bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+
+			ghost, _, err := readGhost(bytes.NewReader(data))
+			if err != nil || ghost["BLOCK_LEADER"] != "SIZE_AS_UINT4" {
+				t.Fatalf("ghost %v %v", ghost, err)
+			}
+
+			r := ReadFrom(bytes.NewReader(data))
+			off, n := r.ifds[0].OriginalTileOffsets[0], r.ifds[0].TileByteCounts[0]
+			if binary.LittleEndian.Uint32(data[off-4:]) != n {
+				t.FailNow()
+			}
+			if first := binary.LittleEndian.Uint32(data[4:]); first%2 != 0 {
+				t.Fatalf("first ifd at odd offset %d", first)
+			}
+
+			corrupt := append([]byte{}, data...)
+			corrupt[off+uint64(n)] ^= 0xff
+			if err := VerifyBlocks(bytes.NewReader(corrupt)); err == nil {
+				t.Fatal("corrupted trailer not detected")
+			}
+			corrupt = append([]byte{}, data...)
+			corrupt[off-4] ^= 0xff
+			if err := VerifyBlocks(bytes.NewReader(corrupt)); err == nil {
+				t.Fatal("corrupted leader not detected")
+			}
+		}
+	}
+}
+
+func TestTagRoundTrip(t *testing.T) {
+	tags := []Tag{
+		{ID: 65000, Type: TypeASCII, Value: "custom"},
+		{ID: TagImageDescription, Type: TypeASCII, Value: "test image"},
+		{ID: TagCopyright, Type: TypeASCII, Value: "(c) flywave"},
+		{ID: TagXResolution, Type: TypeRational, Value: []uint32{300, 1}},
+		{ID: TagOrientation, Type: TypeShort, Value: []uint16{1}},
+		{ID: 50000, Type: TypeDouble, Value: []float64{1.5, 2.5}},
+		{ID: 50001, Type: TypeSRational, Value: []int32{-1, 3}},
+	}
+	if err := WriteTo(&bytes.Buffer{}, []*TileLayer{buildTestLayer(t)}, &Options{Tags: []Tag{{ID: TagImageWidth, Type: TypeLong, Value: []uint32{1}}}}); err == nil {
+		t.Fatal("expected error for structural tag")
+	}
+
+	for _, ctype := range []CompressionType{CTNone, CTLZW} {
+		buf := &bytes.Buffer{}
+		if err := WriteTo(buf, []*TileLayer{buildTestLayer(t)}, &Options{Compression: ctype, Tags: tags}); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		r := ReadFrom(bytes.NewReader(data))
+		if r == nil || r.Data[0] == nil {
+			t.FailNow()
+		}
+		got := map[uint16]Tag{}
+		for _, tag := range r.GetTags(0) {
+			got[tag.ID] = tag
+		}
+		for _, want := range tags {
+			if !reflect.DeepEqual(got[want.ID], want) {
+				t.Fatalf("tag %d: got %v want %v", want.ID, got[want.ID], want)
+			}
+		}
+		if *r.ifds[0].ImageDescription != "test image" {
+			t.FailNow()
+		}
+
+		tif, err := tiff.Parse(bytes.NewReader(data), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := uint16(0)
+		for _, f := range tif.IFDs()[0].Fields() {
+			if f.Tag().ID() <= last {
+				t.Fatalf("tag %d written after %d", f.Tag().ID(), last)
+			}
+			last = f.Tag().ID()
+		}
+
+		buf = &bytes.Buffer{}
+		if err := WriteTo(buf, []*TileLayer{buildTestLayer(t)}, &Options{Tags: r.GetTags(0)}); err != nil {
+			t.Fatal(err)
+		}
+		again := ReadFrom(bytes.NewReader(buf.Bytes()))
+		if !reflect.DeepEqual(again.GetTags(0), r.GetTags(0)) {
+			t.Fatalf("tags changed on rewrite: %v", again.GetTags(0))
+		}
+	}
+}
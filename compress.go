@@ -2,6 +2,11 @@ package cog
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
 	"io"
 )
 
@@ -47,3 +52,66 @@ func unpackBits(r io.Reader) ([]byte, error) {
 		}
 	}
 }
+
+// decodeJPEG decodes a JPEG compressed block, completing an abbreviated
+// stream with the JPEGTables of the IFD, and returns its samples laid out
+// as an uncompressed block of the given size.
+func (ifd *IFD) decodeJPEG(data []byte, blockWidth, blockHeight int) ([]byte, error) {
+	stream := data
+	if t := ifd.JPEGTables; len(t) > 4 && len(data) > 2 {
+		stream = make([]byte, 0, len(t)+len(data))
+		stream = append(stream, t[:len(t)-2]...)
+		stream = append(stream, data[2:]...)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	at := func(x, y int) (int, int) {
+		return b.Min.X + minInt(x, b.Dx()-1), b.Min.Y + minInt(y, b.Dy()-1)
+	}
+
+	switch ifd.PhotometricInterpretation {
+	case PI_YCbCr:
+		h, v := ifd.ycbcrSubsampling()
+		ycc := func(x, y int) color.YCbCr {
+			x, y = at(x, y)
+			if m, ok := img.(*image.YCbCr); ok {
+				return m.YCbCrAt(x, y)
+			}
+			return color.YCbCrModel.Convert(img.At(x, y)).(color.YCbCr)
+		}
+		var buf []byte
+		for y0 := 0; y0 < blockHeight; y0 += v {
+			for x0 := 0; x0 < blockWidth; x0 += h {
+				for j := 0; j < v; j++ {
+					for i := 0; i < h; i++ {
+						buf = append(buf, ycc(x0+i, y0+j).Y)
+					}
+				}
+				c := ycc(x0, y0)
+				buf = append(buf, c.Cb, c.Cr)
+			}
+		}
+		return buf, nil
+	case PI_RGB:
+		buf := make([]byte, 0, blockWidth*blockHeight*3)
+		for y := 0; y < blockHeight; y++ {
+			for x := 0; x < blockWidth; x++ {
+				c := color.RGBAModel.Convert(img.At(at(x, y))).(color.RGBA)
+				buf = append(buf, c.R, c.G, c.B)
+			}
+		}
+		return buf, nil
+	case PI_BlackIsZero, PI_WhiteIsZero:
+		buf := make([]byte, 0, blockWidth*blockHeight)
+		for y := 0; y < blockHeight; y++ {
+			for x := 0; x < blockWidth; x++ {
+				buf = append(buf, color.GrayModel.Convert(img.At(at(x, y))).(color.Gray).Y)
+			}
+		}
+		return buf, nil
+	}
+	return nil, errors.New("unsupported photometric interpretation for JPEG")
+}
@@ -0,0 +1,230 @@
+package cog
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// ConvertOptions controls how samples are mapped to the output type. The
+// first of Percentiles, SrcRange and Scale that is set selects the mapping;
+// without any of them values are copied and clamped to the output type.
+type ConvertOptions struct {
+	// Scale and Offset map a sample v to v*Scale+Offset.
+	Scale  float64
+	Offset float64
+
+	// SrcRange is linearly mapped to DstRange, which defaults to the full
+	// range of integer output types and to [0, 1] for floating point ones.
+	SrcRange *[2]float64
+	DstRange *[2]float64
+
+	// Percentiles sets SrcRange per band from the given low and high
+	// percentiles (0-100) of the valid samples.
+	Percentiles *[2]float64
+
+	// Clip clamps mapped values to DstRange or its default.
+	Clip bool
+
+	// Samples equal to NoData (or NaN) are written as DstNoData, which
+	// defaults to NoData.
+	NoData    *float64
+	DstNoData *float64
+
+	// AvoidNoData stores valid samples that would become DstNoData in an
+	// integer output type as the nearest other value.
+	AvoidNoData bool
+}
+
+func (t DataType) valueRange() [2]float64 {
+	bits := float64(t.BitsPerSample() / uint16(t.info().parts))
+	switch t.SampleFormat() {
+	case SampleFormatIEEEFP, SampleFormatComplexIEEEFP:
+		return [2]float64{0, 1}
+	case SampleFormatInt, SampleFormatComplexInt:
+		return [2]float64{-math.Exp2(bits - 1), math.Exp2(bits-1) - 1}
+	}
+	return [2]float64{0, math.Exp2(bits) - 1}
+}
+
+// Convert returns data as a raster of type to. Complex input is converted
+// using its real part unless the output is complex too.
+func Convert(data interface{}, width, height int, to DataType, opts *ConvertOptions) (*Raster, error) {
+	if to == DTUnknown {
+		return nil, errors.New("unknown output data type")
+	}
+	if opts == nil {
+		opts = &ConvertOptions{}
+	}
+	src, err := newPixelBuffer(data, width, height)
+	if err != nil {
+		return nil, err
+	}
+	inParts, outParts := src.data.Type.info().parts, to.info().parts
+	bands := src.bands / inParts
+	dst := NewRaster(width, height, bands, to)
+
+	noData := opts.NoData
+	dstNoData := opts.DstNoData
+	if dstNoData == nil {
+		dstNoData = noData
+	}
+	valid := func(v float64) bool {
+		return !math.IsNaN(v) && !isNoData(noData, v)
+	}
+
+	for b := 0; b < bands; b++ {
+		k, c, err := opts.mapping(src, b*inParts, to, valid)
+		if err != nil {
+			return nil, err
+		}
+		lo, hi := math.Inf(-1), math.Inf(1)
+		if r, ok := opts.clipRange(to); ok {
+			lo, hi = math.Min(r[0], r[1]), math.Max(r[0], r[1])
+		}
+		for i := 0; i < width*height; i++ {
+			v := src.get(i, b*inParts)
+			if !valid(v) {
+				if dstNoData != nil {
+					dst.set(i, b*outParts, *dstNoData)
+				} else {
+					dst.set(i, b*outParts, math.NaN())
+				}
+				continue
+			}
+			v = math.Min(math.Max(v*k+c, lo), hi)
+			if opts.AvoidNoData && dstNoData != nil {
+				v = to.avoid(v, *dstNoData)
+			}
+			dst.set(i, b*outParts, v)
+			if outParts == 2 && inParts == 2 {
+				dst.set(i, b*outParts+1, src.get(i, b*inParts+1)*k)
+			}
+		}
+	}
+	return dst, nil
+}
+
+// avoid returns v, or the nearest other value of an integer type t when v
+// would be stored as noData.
+func (t DataType) avoid(v, noData float64) float64 {
+	switch t.SampleFormat() {
+	case SampleFormatIEEEFP, SampleFormatComplexIEEEFP:
+		return v
+	}
+	r := t.valueRange()
+	s := roundClamp(v, r[0], r[1])
+	if s != noData {
+		return v
+	}
+	if (v >= s && s < r[1]) || s == r[0] {
+		return s + 1
+	}
+	return s - 1
+}
+
+// clipRange is DstRange, or its default when a range mapping is used.
+// Integer output is always clamped to its type when stored.
+func (o *ConvertOptions) clipRange(to DataType) ([2]float64, bool) {
+	switch {
+	case !o.Clip:
+		return [2]float64{}, false
+	case o.DstRange != nil:
+		return *o.DstRange, true
+	case o.Percentiles != nil || o.SrcRange != nil:
+		return to.valueRange(), true
+	}
+	return [2]float64{}, false
+}
+
+// mapping returns the slope and intercept applied to band b.
+func (o *ConvertOptions) mapping(src *pixelBuffer, b int, to DataType, valid func(float64) bool) (float64, float64, error) {
+	var from [2]float64
+	switch {
+	case o.Percentiles != nil:
+		r, err := percentileRange(src, b, o.Percentiles[0], o.Percentiles[1], valid)
+		if err != nil {
+			return 0, 0, err
+		}
+		from = r
+	case o.SrcRange != nil:
+		from = *o.SrcRange
+	case o.Scale != 0:
+		return o.Scale, o.Offset, nil
+	default:
+		return 1, 0, nil
+	}
+	dst := to.valueRange()
+	if o.DstRange != nil {
+		dst = *o.DstRange
+	}
+	if from[1] == from[0] {
+		return 0, dst[0], nil
+	}
+	k := (dst[1] - dst[0]) / (from[1] - from[0])
+	return k, dst[0] - from[0]*k, nil
+}
+
+func percentileRange(src *pixelBuffer, b int, low, high float64, valid func(float64) bool) ([2]float64, error) {
+	if low < 0 || high > 100 || low >= high {
+		return [2]float64{}, errors.New("invalid percentiles")
+	}
+	var vals []float64
+	for i := 0; i < src.width*src.height; i++ {
+		if v := src.get(i, b); valid(v) {
+			vals = append(vals, v)
+		}
+	}
+	if len(vals) == 0 {
+		return [2]float64{}, errors.New("no valid samples")
+	}
+	sort.Float64s(vals)
+	at := func(p float64) float64 {
+		return vals[int(math.Round(p/100*float64(len(vals)-1)))]
+	}
+	return [2]float64{at(low), at(high)}, nil
+}
+
+// PercentileRange returns the low and high percentiles of the valid
+// samples in a band of data, for use as ConvertOptions.SrcRange.
+func PercentileRange(data interface{}, width, height, band int, noData *float64, low, high float64) ([2]float64, error) {
+	src, err := newPixelBuffer(data, width, height)
+	if err != nil {
+		return [2]float64{}, err
+	}
+	if band < 0 || band >= src.bands {
+		return [2]float64{}, errors.New("band out of range")
+	}
+	return percentileRange(src, band, low, high, func(v float64) bool {
+		return !math.IsNaN(v) && !isNoData(noData, v)
+	})
+}
+
+// ConvertSource converts the data of src and returns it as a new source
+// with the same compression. Percentile stretches only see the pixels of
+// src, compute SrcRange from the whole image to keep tiles consistent.
+func ConvertSource(src TileSource, to DataType, opts *ConvertOptions) (*RawSource, error) {
+	rect := src.Bounds()
+	r, err := Convert(src.Data(), rect.Dx(), rect.Dy(), to, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewSource(r, &rect, src.CompressionType()), nil
+}
+
+// Convert converts image i, using the nodata value of the file when opts
+// does not set one.
+func (m Reader) Convert(i int, to DataType, opts *ConvertOptions) (*Raster, error) {
+	if i >= len(m.Data) || m.Data[i] == nil {
+		return nil, errors.New("image data not loaded")
+	}
+	o := ConvertOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.NoData == nil {
+		o.NoData = m.GetNoData(i)
+	}
+	size := m.GetSize(i)
+	return Convert(m.Data[i], int(size[0]), int(size[1]), to, &o)
+}
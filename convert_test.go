@@ -0,0 +1,89 @@
+package cog
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/flywave/go-geo"
+
+	vec2d "github.com/flywave/go3d/float64/vec2"
+)
+
+func TestConvert(t *testing.T) {
+	dem := []float32{-9999, 0, 12.5, 100, 8848.86, float32(math.NaN())}
+	nd, dstNd := -9999.0, 0.0
+	r, err := Convert(dem, 3, 2, DTUInt16, &ConvertOptions{Scale: 10, Offset: 500, NoData: &nd, DstNoData: &dstNd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := r.Data()
+	if want := []uint16{0, 500, 625, 1500, 65535, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("scale/offset: got %v want %v", got, want)
+	}
+
+	img := []uint16{0, 1000, 2000, 3000, 4000, 65535}
+	r, err = Convert(img, 3, 2, DTByte, &ConvertOptions{SrcRange: &[2]float64{1000, 3000}, Clip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Data(); !reflect.DeepEqual(got, []uint8{0, 0, 128, 255, 255, 255}) {
+		t.Fatalf("range: got %v", got)
+	}
+
+	r, err = Convert(img, 3, 2, DTFloat32, &ConvertOptions{Percentiles: &[2]float64{20, 80}, DstRange: &[2]float64{-1, 1}, Clip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Data(); !reflect.DeepEqual(got, []float32{-1, -1, -1.0 / 3, 1.0 / 3, 1, 1}) {
+		t.Fatalf("percentiles: got %v", got)
+	}
+	if pr, err := PercentileRange(img, 3, 2, 0, nil, 20, 80); err != nil || pr != [2]float64{1000, 4000} {
+		t.Fatalf("percentile range %v %v", pr, err)
+	}
+
+	rgba := image.NewRGBA64(image.Rect(0, 0, 3, 2))
+	for i := range rgba.Pix {
+		rgba.Pix[i] = byte(i * 9)
+	}
+	r, err = Convert(rgba, 3, 2, DTByte, &ConvertOptions{SrcRange: &[2]float64{0, 65535}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Bands != 4 || r.At(1, 1, 2) != math.Round(float64(rgba.RGBA64At(1, 1).B)/257) {
+		t.Fatalf("rgba: %d bands, blue %v", r.Bands, r.At(1, 1, 2))
+	}
+
+	c, err := Convert([]complex64{complex(1, 2), complex(-3, 4)}, 2, 1, DTCInt16, &ConvertOptions{Scale: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.Data(); !reflect.DeepEqual(got, []ComplexInt16{{2, 4}, {-6, 8}}) {
+		t.Fatalf("complex: got %v", got)
+	}
+}
+
+func TestConvertSource(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	data := make([]float32, 16*16)
+	for i := range data {
+		data[i] = float32(i) / 4
+	}
+	src, err := ConvertSource(NewSource(data, &rect, CTDeflate), DTUInt16, &ConvertOptions{Scale: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	box := vec2d.Rect{Min: vec2d.T{116, 39}, Max: vec2d.T{117, 40}}
+	if err := WriteTileTo(buf, src, box, geo.NewProj(4326), [2]uint32{16, 16}, nil); err != nil {
+		t.Fatal(err)
+	}
+	r := ReadFrom(bytes.NewReader(buf.Bytes()))
+	d, _ := r.Data[0].Data()
+	pix, ok := d.([]uint16)
+	if !ok || pix[255] != 255 || r.ifds[0].Compression != CTDeflate {
+		t.Fatalf("read back %T", d)
+	}
+}
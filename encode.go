@@ -0,0 +1,176 @@
+package cog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync"
+)
+
+type encodeResult struct {
+	buf  bytes.Buffer
+	ifd  IFD
+	n    uint32
+	skip bool
+	err  error
+	done chan struct{}
+}
+
+func defaultConcurrency() int {
+	return runtime.NumCPU()
+}
+
+func encodeTiles(tiles []*Tile, workers int, skip func(i int) bool, emit func(i int, r *encodeResult) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]*encodeResult, len(tiles))
+	for i := range results {
+		results[i] = &encodeResult{done: make(chan struct{})}
+	}
+
+	jobs := make(chan int)
+	window := make(chan struct{}, workers*2)
+	quit := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				r := results[i]
+				if skip != nil && skip(i) {
+					r.skip = true
+				} else {
+					r.n, _, r.err = tiles[i].Src.Encode(&r.buf, &r.ifd)
+				}
+				close(r.done)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range tiles {
+			select {
+			case window <- struct{}{}:
+			case <-quit:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	var err error
+	for i, r := range results {
+		<-r.done
+		err = r.err
+		if err == nil {
+			err = emit(i, r)
+		}
+		results[i] = nil
+		<-window
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		close(quit)
+	}
+	wg.Wait()
+	return err
+}
+
+func writeBlock(w io.Writer, enc binary.ByteOrder, payload []byte) error {
+	var leader, trailer [4]byte
+	enc.PutUint32(leader[:], uint32(len(payload)))
+	if len(payload) >= 4 {
+		copy(trailer[:], payload[len(payload)-4:])
+	} else {
+		copy(trailer[4-len(payload):], payload)
+	}
+	if _, err := w.Write(leader[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	_, err := w.Write(trailer[:])
+	return err
+}
+
+func horizontalPredictable(bitsPerSample []uint16) bool {
+	if len(bitsPerSample) == 0 {
+		return false
+	}
+	for _, b := range bitsPerSample {
+		if b != bitsPerSample[0] || (b != 8 && b != 16) {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeHorizontalPredictor(buf []byte, width, spp, bps int, enc binary.ByteOrder) {
+	row := width * spp * bps / 8
+	for y := 0; y+row <= len(buf); y += row {
+		line := buf[y : y+row]
+		switch bps {
+		case 8:
+			for i := len(line) - 1; i >= spp; i-- {
+				line[i] -= line[i-spp]
+			}
+		case 16:
+			step := spp * 2
+			for i := len(line) - 2; i >= step; i -= 2 {
+				enc.PutUint16(line[i:], enc.Uint16(line[i:])-enc.Uint16(line[i-step:]))
+			}
+		}
+	}
+}
+
+func decodeHorizontalPredictor(buf []byte, width, spp, bps int, enc binary.ByteOrder) {
+	row := width * spp * bps / 8
+	for y := 0; y+row <= len(buf); y += row {
+		line := buf[y : y+row]
+		switch bps {
+		case 8:
+			for i := spp; i < len(line); i++ {
+				line[i] += line[i-spp]
+			}
+		case 16:
+			step := spp * 2
+			for i := step; i+2 <= len(line); i += 2 {
+				enc.PutUint16(line[i:], enc.Uint16(line[i:])+enc.Uint16(line[i-step:]))
+			}
+		}
+	}
+}
+
+func applyTileIFD(dst, src *IFD) {
+	dst.TileWidth = src.TileWidth
+	dst.TileLength = src.TileLength
+	dst.BitsPerSample = src.BitsPerSample
+	dst.Compression = src.Compression
+	dst.Predictor = src.Predictor
+	dst.PhotometricInterpretation = src.PhotometricInterpretation
+	dst.SamplesPerPixel = src.SamplesPerPixel
+	dst.SampleFormat = src.SampleFormat
+	dst.YCbCrSubSampling = src.YCbCrSubSampling
+	if len(src.Colormap) != 0 {
+		dst.Colormap = src.Colormap
+	}
+	if len(src.ExtraSamples) != 0 {
+		dst.ExtraSamples = src.ExtraSamples
+	}
+	if src.PlanarConfiguration > 0 {
+		dst.PlanarConfiguration = src.PlanarConfiguration
+	}
+	dst.CopyTags(src)
+}
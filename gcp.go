@@ -0,0 +1,313 @@
+package cog
+
+import (
+	"errors"
+	"math"
+)
+
+type GCP struct {
+	Pixel float64
+	Line  float64
+	X     float64
+	Y     float64
+	Z     float64
+}
+
+type PixelTransformer interface {
+	PixelToWorld(px, py float64) (float64, float64)
+	WorldToPixel(x, y float64) (float64, float64)
+}
+
+func (ifd *IFD) GCPs() []GCP {
+	n := len(ifd.ModelTiePointTag) / 6
+	gcps := make([]GCP, n)
+	for i := 0; i < n; i++ {
+		t := ifd.ModelTiePointTag[i*6 : i*6+6]
+		gcps[i] = GCP{Pixel: t[0], Line: t[1], X: t[3], Y: t[4], Z: t[5]}
+	}
+	return gcps
+}
+
+func (ifd *IFD) SetGCPs(gcps []GCP) {
+	ifd.ModelTiePointTag = make([]float64, 0, len(gcps)*6)
+	for _, g := range gcps {
+		ifd.ModelTiePointTag = append(ifd.ModelTiePointTag, g.Pixel, g.Line, 0, g.X, g.Y, g.Z)
+	}
+	ifd.ModelPixelScaleTag = nil
+	ifd.ModelTransformationTag = nil
+}
+
+func (m Reader) GetGCPs(i int) []GCP {
+	return m.ifds[i].GCPs()
+}
+
+func (m Reader) GetPixelTransformer(i int) (PixelTransformer, error) {
+	gt, err := m.ifds[i].Geotransform()
+	if err == nil {
+		return gt, nil
+	}
+	gcps := m.ifds[i].GCPs()
+	switch {
+	case len(gcps) >= 10:
+		return FitPolynomial(gcps, 2)
+	case len(gcps) >= 3:
+		return FitPolynomial(gcps, 1)
+	}
+	if rpc, rerr := m.ifds[i].RPCModel(); rerr == nil {
+		return &RPCTransform{Model: rpc, Height: rpc.HeightOff}, nil
+	}
+	return nil, err
+}
+
+type normalizer struct {
+	cx, cy, scale float64
+}
+
+func newNormalizer(xs, ys []float64) normalizer {
+	var n normalizer
+	for i := range xs {
+		n.cx += xs[i]
+		n.cy += ys[i]
+	}
+	n.cx /= float64(len(xs))
+	n.cy /= float64(len(ys))
+	for i := range xs {
+		n.scale = math.Max(n.scale, math.Max(math.Abs(xs[i]-n.cx), math.Abs(ys[i]-n.cy)))
+	}
+	if n.scale == 0 {
+		n.scale = 1
+	}
+	return n
+}
+
+func (n normalizer) apply(x, y float64) (float64, float64) {
+	return (x - n.cx) / n.scale, (y - n.cy) / n.scale
+}
+
+func gcpCoords(gcps []GCP) (px, py, wx, wy []float64) {
+	px = make([]float64, len(gcps))
+	py = make([]float64, len(gcps))
+	wx = make([]float64, len(gcps))
+	wy = make([]float64, len(gcps))
+	for i, g := range gcps {
+		px[i], py[i], wx[i], wy[i] = g.Pixel, g.Line, g.X, g.Y
+	}
+	return
+}
+
+type polynomial struct {
+	order  int
+	norm   normalizer
+	coeffX []float64
+	coeffY []float64
+}
+
+func polynomialTerms(order int, x, y float64) []float64 {
+	terms := []float64{1, x, y}
+	if order >= 2 {
+		terms = append(terms, x*x, x*y, y*y)
+	}
+	if order >= 3 {
+		terms = append(terms, x*x*x, x*x*y, x*y*y, y*y*y)
+	}
+	return terms
+}
+
+func fitPolynomial(order int, xs, ys, us, vs []float64) (*polynomial, error) {
+	p := &polynomial{order: order, norm: newNormalizer(xs, ys)}
+	nterms := len(polynomialTerms(order, 0, 0))
+	if len(xs) < nterms {
+		return nil, errors.New("not enough GCPs for polynomial order")
+	}
+	ata := make([][]float64, nterms)
+	for i := range ata {
+		ata[i] = make([]float64, nterms)
+	}
+	atu := make([]float64, nterms)
+	atv := make([]float64, nterms)
+	for i := range xs {
+		x, y := p.norm.apply(xs[i], ys[i])
+		t := polynomialTerms(order, x, y)
+		for r := 0; r < nterms; r++ {
+			for c := 0; c < nterms; c++ {
+				ata[r][c] += t[r] * t[c]
+			}
+			atu[r] += t[r] * us[i]
+			atv[r] += t[r] * vs[i]
+		}
+	}
+	var err error
+	if p.coeffX, err = solveLinear(ata, atu); err != nil {
+		return nil, err
+	}
+	if p.coeffY, err = solveLinear(ata, atv); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *polynomial) eval(x, y float64) (float64, float64) {
+	x, y = p.norm.apply(x, y)
+	t := polynomialTerms(p.order, x, y)
+	var u, v float64
+	for i := range t {
+		u += p.coeffX[i] * t[i]
+		v += p.coeffY[i] * t[i]
+	}
+	return u, v
+}
+
+type PolynomialTransform struct {
+	forward *polynomial
+	inverse *polynomial
+}
+
+func FitPolynomial(gcps []GCP, order int) (*PolynomialTransform, error) {
+	if order < 1 || order > 3 {
+		return nil, errors.New("polynomial order must be between 1 and 3")
+	}
+	px, py, wx, wy := gcpCoords(gcps)
+	fwd, err := fitPolynomial(order, px, py, wx, wy)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := fitPolynomial(order, wx, wy, px, py)
+	if err != nil {
+		return nil, err
+	}
+	return &PolynomialTransform{forward: fwd, inverse: inv}, nil
+}
+
+func (t *PolynomialTransform) PixelToWorld(px, py float64) (float64, float64) {
+	return t.forward.eval(px, py)
+}
+
+func (t *PolynomialTransform) WorldToPixel(x, y float64) (float64, float64) {
+	return t.inverse.eval(x, y)
+}
+
+type thinPlateSpline struct {
+	norm    normalizer
+	xs, ys  []float64
+	weightX []float64
+	weightY []float64
+}
+
+func tpsKernel(r2 float64) float64 {
+	if r2 == 0 {
+		return 0
+	}
+	return r2 * math.Log(r2)
+}
+
+func fitThinPlateSpline(xs, ys, us, vs []float64) (*thinPlateSpline, error) {
+	n := len(xs)
+	if n < 3 {
+		return nil, errors.New("not enough GCPs for thin plate spline")
+	}
+	s := &thinPlateSpline{norm: newNormalizer(xs, ys), xs: make([]float64, n), ys: make([]float64, n)}
+	for i := range xs {
+		s.xs[i], s.ys[i] = s.norm.apply(xs[i], ys[i])
+	}
+	a := make([][]float64, n+3)
+	for i := range a {
+		a[i] = make([]float64, n+3)
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			dx, dy := s.xs[i]-s.xs[j], s.ys[i]-s.ys[j]
+			a[i][j] = tpsKernel(dx*dx + dy*dy)
+		}
+		a[i][n], a[i][n+1], a[i][n+2] = 1, s.xs[i], s.ys[i]
+		a[n][i], a[n+1][i], a[n+2][i] = 1, s.xs[i], s.ys[i]
+	}
+	bu := make([]float64, n+3)
+	bv := make([]float64, n+3)
+	copy(bu, us)
+	copy(bv, vs)
+	var err error
+	if s.weightX, err = solveLinear(a, bu); err != nil {
+		return nil, err
+	}
+	if s.weightY, err = solveLinear(a, bv); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *thinPlateSpline) eval(x, y float64) (float64, float64) {
+	x, y = s.norm.apply(x, y)
+	n := len(s.xs)
+	u := s.weightX[n] + s.weightX[n+1]*x + s.weightX[n+2]*y
+	v := s.weightY[n] + s.weightY[n+1]*x + s.weightY[n+2]*y
+	for i := 0; i < n; i++ {
+		dx, dy := x-s.xs[i], y-s.ys[i]
+		k := tpsKernel(dx*dx + dy*dy)
+		u += s.weightX[i] * k
+		v += s.weightY[i] * k
+	}
+	return u, v
+}
+
+type TPSTransform struct {
+	forward *thinPlateSpline
+	inverse *thinPlateSpline
+}
+
+func FitThinPlateSpline(gcps []GCP) (*TPSTransform, error) {
+	px, py, wx, wy := gcpCoords(gcps)
+	fwd, err := fitThinPlateSpline(px, py, wx, wy)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := fitThinPlateSpline(wx, wy, px, py)
+	if err != nil {
+		return nil, err
+	}
+	return &TPSTransform{forward: fwd, inverse: inv}, nil
+}
+
+func (t *TPSTransform) PixelToWorld(px, py float64) (float64, float64) {
+	return t.forward.eval(px, py)
+}
+
+func (t *TPSTransform) WorldToPixel(x, y float64) (float64, float64) {
+	return t.inverse.eval(x, y)
+}
+
+func solveLinear(a [][]float64, b []float64) ([]float64, error) {
+	n := len(b)
+	m := make([][]float64, n)
+	for i := range a {
+		m[i] = make([]float64, n+1)
+		copy(m[i], a[i])
+		m[i][n] = b[i]
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return nil, errors.New("singular system, GCPs are degenerate")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for r := col + 1; r < n; r++ {
+			f := m[r][col] / m[col][col]
+			for c := col; c <= n; c++ {
+				m[r][c] -= f * m[col][c]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		sum := m[r][n]
+		for c := r + 1; c < n; c++ {
+			sum -= m[r][c] * x[c]
+		}
+		x[r] = sum / m[r][r]
+	}
+	return x, nil
+}
@@ -0,0 +1,102 @@
+package cog
+
+import (
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flywave/go-geo"
+
+	vec2d "github.com/flywave/go3d/float64/vec2"
+)
+
+func makeGCPs(f func(px, py float64) (float64, float64)) []GCP {
+	gcps := []GCP{}
+	for py := 0.0; py <= 1000; py += 250 {
+		for px := 0.0; px <= 1000; px += 250 {
+			x, y := f(px, py)
+			gcps = append(gcps, GCP{Pixel: px, Line: py, X: x, Y: y})
+		}
+	}
+	return gcps
+}
+
+func TestPolynomialFit(t *testing.T) {
+	gt := GeoTransform{500000, 0.5, 0.01, 4400000, 0.02, -0.5}
+	gcps := makeGCPs(gt.PixelToWorld)
+
+	for order := 1; order <= 3; order++ {
+		tr, err := FitPolynomial(gcps, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, y := tr.PixelToWorld(333, 777)
+		ex, ey := gt.PixelToWorld(333, 777)
+		if math.Abs(x-ex) > 1e-4 || math.Abs(y-ey) > 1e-4 {
+			t.Fatalf("order %d: got %v,%v want %v,%v", order, x, y, ex, ey)
+		}
+		px, py := tr.WorldToPixel(ex, ey)
+		if math.Abs(px-333) > 1e-4 || math.Abs(py-777) > 1e-4 {
+			t.Fatalf("order %d: inverse got %v,%v", order, px, py)
+		}
+	}
+
+	if _, err := FitPolynomial(gcps[:5], 2); err == nil {
+		t.FailNow()
+	}
+}
+
+func TestThinPlateSpline(t *testing.T) {
+	warp := func(px, py float64) (float64, float64) {
+		return 100 + px*0.1 + math.Sin(py/300), 50 - py*0.1 + math.Cos(px/300)
+	}
+	gcps := makeGCPs(warp)
+	tr, err := FitThinPlateSpline(gcps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range gcps {
+		x, y := tr.PixelToWorld(g.Pixel, g.Line)
+		if math.Abs(x-g.X) > 1e-6 || math.Abs(y-g.Y) > 1e-6 {
+			t.Fatalf("gcp not honoured: %v,%v vs %v", x, y, g)
+		}
+		px, py := tr.WorldToPixel(g.X, g.Y)
+		if math.Abs(px-g.Pixel) > 1e-6 || math.Abs(py-g.Line) > 1e-6 {
+			t.Fatalf("inverse gcp not honoured: %v,%v vs %v", px, py, g)
+		}
+	}
+}
+
+func TestWriteGCPs(t *testing.T) {
+	data := make([]uint16, 16*16)
+	rect := image.Rect(0, 0, 16, 16)
+	gcps := makeGCPs(GeoTransform{116, 0.001, 0, 40, 0, -0.001}.PixelToWorld)
+
+	w := NewTileWriter(NewSource(data, &rect, CTLZW), tiffByteOrder, false, vec2d.Rect{}, geo.NewProj(4326), [2]uint32{16, 16}, nil)
+	w.SetGCPs(gcps)
+
+	name := filepath.Join(t.TempDir(), "gcps.tif")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteData(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r := Read(name)
+	read := r.GetGCPs(0)
+	if len(read) != len(gcps) || read[7] != gcps[7] {
+		t.FailNow()
+	}
+	tr, err := r.GetPixelTransformer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tr.(*PolynomialTransform); !ok {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,263 @@
+package cog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	gdalStatisticsMinimum      = "STATISTICS_MINIMUM"
+	gdalStatisticsMaximum      = "STATISTICS_MAXIMUM"
+	gdalStatisticsMean         = "STATISTICS_MEAN"
+	gdalStatisticsStdDev       = "STATISTICS_STDDEV"
+	gdalStatisticsValidPercent = "STATISTICS_VALID_PERCENT"
+	gdalStatisticsApproximate  = "STATISTICS_APPROXIMATE"
+)
+
+// GDALMetadata is the content of the GDAL_METADATA tag (42112). Items maps
+// a metadata domain ("" for the default one) to its key/value pairs.
+type GDALMetadata struct {
+	Items map[string]map[string]string
+	Bands []BandMetadata
+}
+
+type BandMetadata struct {
+	Description string
+	Scale       *float64
+	Offset      *float64
+	Unit        string
+	ColorInterp string
+	Statistics  *BandStatistics
+	Items       map[string]map[string]string
+}
+
+type BandStatistics struct {
+	Minimum      float64
+	Maximum      float64
+	Mean         float64
+	StdDev       float64
+	ValidPercent *float64
+	Approximate  bool
+}
+
+type gdalMetadataItem struct {
+	Name   string `xml:"name,attr"`
+	Domain string `xml:"domain,attr,omitempty"`
+	Sample string `xml:"sample,attr,omitempty"`
+	Role   string `xml:"role,attr,omitempty"`
+	Value  string `xml:",chardata"`
+}
+
+type gdalMetadataDoc struct {
+	XMLName xml.Name           `xml:"GDALMetadata"`
+	Items   []gdalMetadataItem `xml:"Item"`
+}
+
+func NewGDALMetadata() *GDALMetadata {
+	return &GDALMetadata{Items: make(map[string]map[string]string)}
+}
+
+func (m *GDALMetadata) Set(domain, key, value string) {
+	m.Items = setMetadataItem(m.Items, domain, key, value)
+}
+
+func (m *GDALMetadata) Get(domain, key string) (string, bool) {
+	v, ok := m.Items[domain][key]
+	return v, ok
+}
+
+func (m *GDALMetadata) Band(i int) *BandMetadata {
+	for len(m.Bands) <= i {
+		m.Bands = append(m.Bands, BandMetadata{})
+	}
+	return &m.Bands[i]
+}
+
+func (b *BandMetadata) Set(domain, key, value string) {
+	b.Items = setMetadataItem(b.Items, domain, key, value)
+}
+
+func (b *BandMetadata) Get(domain, key string) (string, bool) {
+	v, ok := b.Items[domain][key]
+	return v, ok
+}
+
+func setMetadataItem(items map[string]map[string]string, domain, key, value string) map[string]map[string]string {
+	if items == nil {
+		items = make(map[string]map[string]string)
+	}
+	if items[domain] == nil {
+		items[domain] = make(map[string]string)
+	}
+	items[domain][key] = value
+	return items
+}
+
+func ParseGDALMetadata(s string) (*GDALMetadata, error) {
+	var doc gdalMetadataDoc
+	if err := xml.Unmarshal([]byte(s), &doc); err != nil {
+		return nil, fmt.Errorf("parse gdal metadata: %w", err)
+	}
+	m := NewGDALMetadata()
+	for _, it := range doc.Items {
+		if it.Sample == "" {
+			m.Set(it.Domain, it.Name, it.Value)
+			continue
+		}
+		sample, err := strconv.Atoi(it.Sample)
+		if err != nil || sample < 0 {
+			return nil, fmt.Errorf("parse gdal metadata: invalid sample %q", it.Sample)
+		}
+		b := m.Band(sample)
+		switch strings.ToLower(it.Role) {
+		case "description":
+			b.Description = it.Value
+		case "scale":
+			b.Scale = parseMetadataFloat(it.Value)
+		case "offset":
+			b.Offset = parseMetadataFloat(it.Value)
+		case "unittype":
+			b.Unit = it.Value
+		case "colorinterp":
+			b.ColorInterp = it.Value
+		default:
+			b.Set(it.Domain, it.Name, it.Value)
+		}
+	}
+	for i := range m.Bands {
+		m.Bands[i].takeStatistics()
+	}
+	return m, nil
+}
+
+func parseMetadataFloat(s string) *float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+func formatMetadataFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (b *BandMetadata) takeStatistics() {
+	items := b.Items[""]
+	min, max := parseMetadataFloat(items[gdalStatisticsMinimum]), parseMetadataFloat(items[gdalStatisticsMaximum])
+	mean, stddev := parseMetadataFloat(items[gdalStatisticsMean]), parseMetadataFloat(items[gdalStatisticsStdDev])
+	if min == nil || max == nil || mean == nil || stddev == nil {
+		return
+	}
+	b.Statistics = &BandStatistics{Minimum: *min, Maximum: *max, Mean: *mean, StdDev: *stddev}
+	b.Statistics.ValidPercent = parseMetadataFloat(items[gdalStatisticsValidPercent])
+	b.Statistics.Approximate = strings.EqualFold(items[gdalStatisticsApproximate], "YES")
+	for _, k := range []string{gdalStatisticsMinimum, gdalStatisticsMaximum, gdalStatisticsMean, gdalStatisticsStdDev, gdalStatisticsValidPercent, gdalStatisticsApproximate} {
+		delete(items, k)
+	}
+	if len(items) == 0 {
+		delete(b.Items, "")
+	}
+}
+
+func appendMetadataItems(dst []gdalMetadataItem, items map[string]map[string]string, sample string) []gdalMetadataItem {
+	domains := make([]string, 0, len(items))
+	for d := range items {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	for _, d := range domains {
+		keys := make([]string, 0, len(items[d]))
+		for k := range items[d] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			dst = append(dst, gdalMetadataItem{Name: k, Domain: d, Sample: sample, Value: items[d][k]})
+		}
+	}
+	return dst
+}
+
+func (m *GDALMetadata) Empty() bool {
+	if m == nil {
+		return true
+	}
+	for _, items := range m.Items {
+		if len(items) > 0 {
+			return false
+		}
+	}
+	for _, b := range m.Bands {
+		if b.Description != "" || b.Scale != nil || b.Offset != nil || b.Unit != "" || b.ColorInterp != "" || b.Statistics != nil || len(b.Items) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *GDALMetadata) Marshal() (string, error) {
+	doc := gdalMetadataDoc{Items: appendMetadataItems(nil, m.Items, "")}
+	for i, b := range m.Bands {
+		sample := strconv.Itoa(i)
+		doc.Items = appendMetadataItems(doc.Items, b.Items, sample)
+		if s := b.Statistics; s != nil {
+			stats := map[string]string{
+				gdalStatisticsMinimum: formatMetadataFloat(s.Minimum),
+				gdalStatisticsMaximum: formatMetadataFloat(s.Maximum),
+				gdalStatisticsMean:    formatMetadataFloat(s.Mean),
+				gdalStatisticsStdDev:  formatMetadataFloat(s.StdDev),
+			}
+			if s.ValidPercent != nil {
+				stats[gdalStatisticsValidPercent] = formatMetadataFloat(*s.ValidPercent)
+			}
+			if s.Approximate {
+				stats[gdalStatisticsApproximate] = "YES"
+			}
+			doc.Items = appendMetadataItems(doc.Items, map[string]map[string]string{"": stats}, sample)
+		}
+		if b.Description != "" {
+			doc.Items = append(doc.Items, gdalMetadataItem{Name: "DESCRIPTION", Sample: sample, Role: "description", Value: b.Description})
+		}
+		if b.Scale != nil {
+			doc.Items = append(doc.Items, gdalMetadataItem{Name: "SCALE", Sample: sample, Role: "scale", Value: formatMetadataFloat(*b.Scale)})
+		}
+		if b.Offset != nil {
+			doc.Items = append(doc.Items, gdalMetadataItem{Name: "OFFSET", Sample: sample, Role: "offset", Value: formatMetadataFloat(*b.Offset)})
+		}
+		if b.Unit != "" {
+			doc.Items = append(doc.Items, gdalMetadataItem{Name: "UNITTYPE", Sample: sample, Role: "unittype", Value: b.Unit})
+		}
+		if b.ColorInterp != "" {
+			doc.Items = append(doc.Items, gdalMetadataItem{Name: "COLORINTERP", Sample: sample, Role: "colorinterp", Value: b.ColorInterp})
+		}
+	}
+	out, err := xml.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (ifd *IFD) Metadata() (*GDALMetadata, error) {
+	if ifd.GDALMetaData == "" {
+		return NewGDALMetadata(), nil
+	}
+	return ParseGDALMetadata(ifd.GDALMetaData)
+}
+
+func (ifd *IFD) SetMetadata(m *GDALMetadata) error {
+	if m.Empty() {
+		ifd.GDALMetaData = ""
+		return nil
+	}
+	s, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	ifd.GDALMetaData = s
+	return nil
+}
@@ -0,0 +1,73 @@
+package cog
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestGDALMetadataRoundTrip(t *testing.T) {
+	src := `<GDALMetadata>
+  <Item name="AREA_OR_POINT">Area</Item>
+  <Item name="SOURCE" domain="PROVENANCE">survey &amp; scan</Item>
+  <Item name="STATISTICS_MAXIMUM" sample="0">254</Item>
+  <Item name="STATISTICS_MEAN" sample="0">100.5</Item>
+  <Item name="STATISTICS_MINIMUM" sample="0">1</Item>
+  <Item name="STATISTICS_STDDEV" sample="0">12.25</Item>
+  <Item name="DESCRIPTION" sample="0" role="description">elevation</Item>
+  <Item name="SCALE" sample="0" role="scale">0.1</Item>
+  <Item name="OFFSET" sample="0" role="offset">-10</Item>
+  <Item name="UNITTYPE" sample="0" role="unittype">m</Item>
+  <Item name="WAVELENGTH" sample="1">850</Item>
+  <Item name="COLORINTERP" sample="1" role="colorinterp">Undefined</Item>
+</GDALMetadata>`
+	md, err := ParseGDALMetadata(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := md.Get("PROVENANCE", "SOURCE"); v != "survey & scan" || len(md.Bands) != 2 {
+		t.Fatalf("unexpected metadata %+v", md)
+	}
+	b := md.Bands[0]
+	if b.Description != "elevation" || *b.Scale != 0.1 || *b.Offset != -10 || b.Unit != "m" || len(b.Items) != 0 {
+		t.Fatalf("unexpected band %+v", b)
+	}
+	if b.Statistics == nil || b.Statistics.Maximum != 254 || b.Statistics.StdDev != 12.25 || b.Statistics.ValidPercent != nil {
+		t.Fatalf("unexpected statistics %+v", b.Statistics)
+	}
+	if v, _ := md.Bands[1].Get("", "WAVELENGTH"); v != "850" || md.Bands[1].ColorInterp != "Undefined" {
+		t.Fatalf("unexpected band %+v", md.Bands[1])
+	}
+
+	out, err := md.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ParseGDALMetadata(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(md, again) {
+		t.Fatalf("round trip changed metadata:\n%s", out)
+	}
+
+	if _, err := ParseGDALMetadata(`<GDALMetadata><Item name="X" sample="a">1</Item></GDALMetadata>`); err == nil {
+		t.Fatal("expected error for invalid sample")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteTo(buf, []*TileLayer{buildTestLayer(t)}, &Options{GDALMetadata: md, Metadata: map[string]string{"AREA_OR_POINT": "Point"}}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := md.Get("", "AREA_OR_POINT"); v != "Area" {
+		t.Fatal("options modified the caller's metadata")
+	}
+	info, err := ReadInfo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	band := info.Bands[0]
+	if info.Metadata[""]["AREA_OR_POINT"] != "Point" || band.Description != "elevation" || band.Unit != "m" || *band.Mean != 100.5 {
+		t.Fatalf("unexpected info %+v %+v", info.Metadata, band)
+	}
+}
@@ -0,0 +1,134 @@
+package cog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/tiff"
+)
+
+const ghostSizePrefix = "GDAL_STRUCTURAL_METADATA_SIZE="
+
+func readHeader(r io.ReaderAt) (binary.ByteOrder, bool, error) {
+	var buf [4]byte
+	if _, err := r.ReadAt(buf[:], 0); err != nil {
+		return nil, false, err
+	}
+	var enc binary.ByteOrder
+	switch string(buf[:2]) {
+	case "II":
+		enc = binary.LittleEndian
+	case "MM":
+		enc = binary.BigEndian
+	default:
+		return nil, false, errors.New("not a tiff file")
+	}
+	switch enc.Uint16(buf[2:]) {
+	case 42:
+		return enc, false, nil
+	case 43:
+		return enc, true, nil
+	}
+	return nil, false, errors.New("unknown tiff version")
+}
+
+func readGhost(r io.ReaderAt) (map[string]string, int64, error) {
+	_, bigtiff, err := readHeader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	off := int64(8)
+	if bigtiff {
+		off = 16
+	}
+
+	head := make([]byte, len(ghostSizePrefix)+len("000000 bytes\n"))
+	if _, err := r.ReadAt(head, off); err != nil {
+		return nil, 0, nil
+	}
+	if !strings.HasPrefix(string(head), ghostSizePrefix) {
+		return nil, 0, nil
+	}
+	size, err := strconv.Atoi(string(head[len(ghostSizePrefix) : len(ghostSizePrefix)+6]))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid ghost area size: %w", err)
+	}
+
+	body := make([]byte, size)
+	if _, err := r.ReadAt(body, off+int64(len(head))); err != nil {
+		return nil, 0, fmt.Errorf("read ghost area: %w", err)
+	}
+	keys := make(map[string]string)
+	for _, line := range strings.Split(string(body), "\n") {
+		if kv := strings.SplitN(strings.TrimSpace(line), "=", 2); len(kv) == 2 {
+			keys[kv[0]] = kv[1]
+		}
+	}
+	return keys, int64(len(head) + size), nil
+}
+
+func VerifyBlocks(r tiff.ReadAtReadSeeker) error {
+	ghost, _, err := readGhost(r)
+	if err != nil {
+		return err
+	}
+	leader := ghost["BLOCK_LEADER"] == "SIZE_AS_UINT4"
+	trailer := ghost["BLOCK_TRAILER"] == "LAST_4_BYTES_REPEATED"
+	if !leader && !trailer {
+		return nil
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tif, err := tiff.Parse(r, nil, nil)
+	if err != nil {
+		return err
+	}
+	enc := tif.R().ByteOrder()
+	for i, tifd := range tif.IFDs() {
+		ifd, err := loadIFD(tif.R(), tifd)
+		if err != nil {
+			return err
+		}
+		for j, off := range ifd.OriginalTileOffsets {
+			if j >= len(ifd.TileByteCounts) || ifd.TileByteCounts[j] == 0 {
+				continue
+			}
+			n := ifd.TileByteCounts[j]
+			if off < 4 {
+				return fmt.Errorf("ifd %d tile %d: offset %d leaves no room for a block leader", i, j, off)
+			}
+			var buf [4]byte
+			if leader {
+				if _, err := r.ReadAt(buf[:], int64(off)-4); err != nil {
+					return fmt.Errorf("ifd %d tile %d: read leader: %w", i, j, err)
+				}
+				if size := enc.Uint32(buf[:]); size != n {
+					return fmt.Errorf("ifd %d tile %d: leader size %d does not match byte count %d", i, j, size, n)
+				}
+			}
+			if trailer {
+				var last, tail [4]byte
+				k := 4
+				if n < 4 {
+					k = int(n)
+				}
+				if _, err := r.ReadAt(last[4-k:], int64(off)+int64(n)-int64(k)); err != nil {
+					return fmt.Errorf("ifd %d tile %d: read data: %w", i, j, err)
+				}
+				if _, err := r.ReadAt(tail[:], int64(off)+int64(n)); err != nil {
+					return fmt.Errorf("ifd %d tile %d: read trailer: %w", i, j, err)
+				}
+				if last != tail {
+					return fmt.Errorf("ifd %d tile %d: trailer does not repeat the last 4 bytes of the block", i, j)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -3,10 +3,13 @@ package cog
 import (
 	"bytes"
 	"errors"
+	"math"
 	"math/big"
 	"sort"
 	"strings"
 
+	vec2d "github.com/flywave/go3d/float64/vec2"
+
 	"github.com/google/tiff"
 )
 
@@ -54,9 +57,11 @@ type IFD struct {
 	NewTileOffsets32          []uint32
 	TempTileByteCounts        []uint64 `tiff:"field,tag=325"`
 	TileByteCounts            []uint32
+	InkSet                    uint16   `tiff:"field,tag=332"`
 	ExtraSamples              []uint16 `tiff:"field,tag=338"`
 	SampleFormat              []uint16 `tiff:"field,tag=339"`
 	JPEGTables                []byte   `tiff:"field,tag=347"`
+	YCbCrSubSampling          []uint16 `tiff:"field,tag=530"`
 
 	Copyright *string `tiff:"field,tag=33432"`
 
@@ -71,6 +76,8 @@ type IFD struct {
 	LERCParams             []uint32  `tiff:"field,tag=50674"`
 	RPCs                   []float64 `tiff:"field,tag=50844"`
 
+	ExtraTags []Tag
+
 	ntags      uint64
 	nplanes    uint64 //1 if PlanarConfiguration==1, SamplesPerPixel if PlanarConfiguration==2
 	tagsSize   uint64
@@ -161,15 +168,70 @@ func (gt GeoTransform) Scale() (float64, float64) {
 	return gt[1], -gt[5]
 }
 
+func (gt GeoTransform) IsNorthUp() bool {
+	return gt[2] == 0 && gt[4] == 0
+}
+
+func (gt GeoTransform) PixelToWorld(px, py float64) (float64, float64) {
+	return gt[0] + px*gt[1] + py*gt[2], gt[3] + px*gt[4] + py*gt[5]
+}
+
+func (gt GeoTransform) WorldToPixel(x, y float64) (float64, float64) {
+	inv, err := gt.Inverse()
+	if err != nil {
+		return math.NaN(), math.NaN()
+	}
+	return inv.PixelToWorld(x, y)
+}
+
+func (gt GeoTransform) Inverse() (GeoTransform, error) {
+	det := gt[1]*gt[5] - gt[2]*gt[4]
+	if det == 0 {
+		return GeoTransform{}, errors.New("geotransform is not invertible")
+	}
+	inv := 1 / det
+	return GeoTransform{
+		(gt[2]*gt[3] - gt[0]*gt[5]) * inv,
+		gt[5] * inv,
+		-gt[2] * inv,
+		(gt[0]*gt[4] - gt[1]*gt[3]) * inv,
+		-gt[4] * inv,
+		gt[1] * inv,
+	}, nil
+}
+
+func (gt GeoTransform) Bounds(width, height int) vec2d.Rect {
+	box := vec2d.Rect{Min: vec2d.MaxVal, Max: vec2d.MinVal}
+	for _, c := range [4][2]float64{{0, 0}, {float64(width), 0}, {0, float64(height)}, {float64(width), float64(height)}} {
+		x, y := gt.PixelToWorld(c[0], c[1])
+		box.Extend(&vec2d.T{x, y})
+	}
+	return box
+}
+
+func (ifd *IFD) SetGeoTransform(gt GeoTransform) {
+	if gt.IsNorthUp() {
+		ifd.ModelTiePointTag = []float64{0, 0, 0, gt[0], gt[3], 0}
+		ifd.ModelPixelScaleTag = []float64{gt[1], -gt[5], 0}
+		ifd.ModelTransformationTag = nil
+		return
+	}
+	ifd.ModelTiePointTag = nil
+	ifd.ModelPixelScaleTag = nil
+	ifd.ModelTransformationTag = []float64{
+		gt[1], gt[2], 0, gt[0],
+		gt[4], gt[5], 0, gt[3],
+		0, 0, 0, 0,
+		0, 0, 0, 1,
+	}
+}
+
 func (ifd *IFD) Geotransform() (GeoTransform, error) {
 	gt := GeoTransform{0, 1, 0, 0, 0, 1}
 	if len(ifd.ModelPixelScaleTag) >= 2 &&
 		ifd.ModelPixelScaleTag[0] != 0 && ifd.ModelPixelScaleTag[1] != 0 {
 		gt[1] = ifd.ModelPixelScaleTag[0]
 		gt[5] = -ifd.ModelPixelScaleTag[1]
-		if gt[5] > 0 {
-			return gt, errors.New("negativ y-scale not supported")
-		}
 
 		if len(ifd.ModelTiePointTag) >= 6 {
 			gt[0] =
@@ -192,144 +254,150 @@ func (ifd *IFD) Geotransform() (GeoTransform, error) {
 	return gt, nil
 }
 
-func (ifd *IFD) structure(bigtiff bool) (tagCount, ifdSize, strileSize, planeCount uint64) {
-	cnt := uint64(0)
-	size := uint64(16) //8 for field count + 8 for next ifd offset
-	tagSize := uint64(20)
-	planeCount = 1
-	if !bigtiff {
-		size = 6 // 2 for field count + 4 for next ifd offset
-		tagSize = 12
+type ifdEntry struct {
+	Tag
+	strile bool
+}
+
+func (ifd *IFD) entries() []ifdEntry {
+	var entries []ifdEntry
+	add := func(id, typ uint16, v interface{}) {
+		entries = append(entries, ifdEntry{Tag: Tag{ID: id, Type: typ, Value: v}})
+	}
+	strile := func(id, typ uint16, v interface{}) {
+		entries = append(entries, ifdEntry{Tag: Tag{ID: id, Type: typ, Value: v}, strile: true})
 	}
-	strileSize = uint64(0)
 
 	if ifd.NewSubfileType > 0 {
-		cnt++
-		size += tagSize
+		add(TagNewSubfileType, tLong, []uint32{ifd.NewSubfileType})
 	}
 	if ifd.ImageWidth > 0 {
-		cnt++
-		size += tagSize
+		add(TagImageWidth, tLong, []uint32{uint32(ifd.ImageWidth)})
 	}
 	if ifd.ImageLength > 0 {
-		cnt++
-		size += tagSize
+		add(TagImageLength, tLong, []uint32{uint32(ifd.ImageLength)})
 	}
 	if len(ifd.BitsPerSample) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.BitsPerSample, bigtiff)
+		add(TagBitsPerSample, tShort, ifd.BitsPerSample)
 	}
 	if ifd.Compression > 0 {
-		cnt++
-		size += tagSize
-	}
-
-	cnt++ /*PhotometricInterpretation*/
-	size += tagSize
-
-	if len(ifd.DocumentName) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.DocumentName, bigtiff)
+		add(TagCompression, tShort, []uint16{ifd.Compression})
 	}
+	add(TagPhotometricInterpretation, tShort, []uint16{ifd.PhotometricInterpretation})
 	if ifd.SamplesPerPixel > 0 {
-		cnt++
-		size += tagSize
+		add(TagSamplesPerPixel, tShort, []uint16{ifd.SamplesPerPixel})
 	}
 	if ifd.PlanarConfiguration > 0 {
-		cnt++
-		size += tagSize
-	}
-	if ifd.PlanarConfiguration == 2 {
-		planeCount = uint64(ifd.SamplesPerPixel)
-	}
-	if len(ifd.DateTime) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.DateTime, bigtiff)
+		add(TagPlanarConfiguration, tShort, []uint16{ifd.PlanarConfiguration})
 	}
 	if ifd.Predictor > 0 {
-		cnt++
-		size += tagSize
+		add(TagPredictor, tShort, []uint16{ifd.Predictor})
 	}
 	if len(ifd.Colormap) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.Colormap, bigtiff)
+		add(TagColorMap, tShort, ifd.Colormap)
 	}
 	if ifd.TileWidth > 0 {
-		cnt++
-		size += tagSize
+		add(TagTileWidth, tShort, []uint16{ifd.TileWidth})
 	}
 	if ifd.TileLength > 0 {
-		cnt++
-		size += tagSize
+		add(TagTileLength, tShort, []uint16{ifd.TileLength})
 	}
 	if len(ifd.NewTileOffsets32) > 0 {
-		cnt++
-		size += tagSize
-		strileSize += arrayFieldSize(ifd.NewTileOffsets32, bigtiff) - tagSize
+		strile(TagTileOffsets, tLong, ifd.NewTileOffsets32)
 	} else if len(ifd.NewTileOffsets64) > 0 {
-		cnt++
-		size += tagSize
-		strileSize += arrayFieldSize(ifd.NewTileOffsets64, bigtiff) - tagSize
+		strile(TagTileOffsets, tLong8, ifd.NewTileOffsets64)
 	}
 	if len(ifd.TileByteCounts) > 0 {
-		cnt++
-		size += tagSize
-		strileSize += arrayFieldSize(ifd.TileByteCounts, bigtiff) - tagSize
+		strile(TagTileByteCounts, tLong, ifd.TileByteCounts)
+	}
+	if ifd.InkSet > 0 {
+		add(TagInkSet, tShort, []uint16{ifd.InkSet})
 	}
-
 	if len(ifd.ExtraSamples) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.ExtraSamples, bigtiff)
+		add(TagExtraSamples, tShort, ifd.ExtraSamples)
 	}
 	if len(ifd.SampleFormat) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.SampleFormat, bigtiff)
+		add(TagSampleFormat, tShort, ifd.SampleFormat)
 	}
 	if len(ifd.JPEGTables) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.JPEGTables, bigtiff)
+		add(TagJPEGTables, tUndefined, ifd.JPEGTables)
+	}
+	if len(ifd.YCbCrSubSampling) > 0 {
+		add(TagYCbCrSubSampling, tShort, ifd.YCbCrSubSampling)
 	}
 	if len(ifd.ModelPixelScaleTag) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.ModelPixelScaleTag, bigtiff)
+		add(TagModelPixelScaleTag, tDouble, ifd.ModelPixelScaleTag)
 	}
 	if len(ifd.ModelTiePointTag) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.ModelTiePointTag, bigtiff)
+		add(TagModelTiepointTag, tDouble, ifd.ModelTiePointTag)
 	}
 	if len(ifd.ModelTransformationTag) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.ModelTransformationTag, bigtiff)
+		add(TagModelTransformationTag, tDouble, ifd.ModelTransformationTag)
 	}
 	if len(ifd.GeoKeyDirectoryTag) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.GeoKeyDirectoryTag, bigtiff)
+		add(TagGeoKeyDirectoryTag, tShort, ifd.GeoKeyDirectoryTag)
 	}
 	if len(ifd.GeoDoubleParamsTag) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.GeoDoubleParamsTag, bigtiff)
+		add(TagGeoDoubleParamsTag, tDouble, ifd.GeoDoubleParamsTag)
 	}
 	if ifd.GeoAsciiParamsTag != "" {
-		cnt++
-		size += arrayFieldSize(ifd.GeoAsciiParamsTag, bigtiff)
+		add(TagGeoAsciiParamsTag, tAscii, ifd.GeoAsciiParamsTag)
 	}
 	if ifd.GDALMetaData != "" {
-		cnt++
-		size += arrayFieldSize(ifd.GDALMetaData, bigtiff)
+		add(TagGDAL_METADATA, tAscii, ifd.GDALMetaData)
 	}
 	if ifd.NoData != "" {
-		cnt++
-		size += arrayFieldSize(ifd.NoData, bigtiff)
+		add(TagGDAL_NODATA, tAscii, ifd.NoData)
 	}
 	if len(ifd.LERCParams) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.LERCParams, bigtiff)
+		add(TagLERCParams, tLong, ifd.LERCParams)
 	}
 	if len(ifd.RPCs) > 0 {
-		cnt++
-		size += arrayFieldSize(ifd.RPCs, bigtiff)
+		add(TagRPCs, tDouble, ifd.RPCs)
+	}
+
+	seen := make(map[uint16]bool, len(entries))
+	for _, e := range entries {
+		seen[e.ID] = true
+	}
+	for _, t := range ifd.Tags() {
+		if !seen[t.ID] {
+			seen[t.ID] = true
+			entries = append(entries, ifdEntry{Tag: t})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries
+}
+
+func (ifd *IFD) structure(bigtiff bool) (tagCount, ifdSize, strileSize, planeCount uint64) {
+	size := uint64(16) //8 for field count + 8 for next ifd offset
+	tagSize := uint64(20)
+	inline := uint64(8)
+	if !bigtiff {
+		size = 6 // 2 for field count + 4 for next ifd offset
+		tagSize = 12
+		inline = 4
+	}
+	planeCount = 1
+	if ifd.PlanarConfiguration == 2 {
+		planeCount = uint64(ifd.SamplesPerPixel)
+	}
+
+	entries := ifd.entries()
+	for _, e := range entries {
+		size += tagSize
+		n := e.dataLen()
+		if n <= inline {
+			continue
+		}
+		if e.strile {
+			strileSize += n
+		} else {
+			size += (n + 1) &^ 1 // values start on a word boundary
+		}
 	}
-	return cnt, size, strileSize, planeCount
+	return uint64(len(entries)), size, strileSize, planeCount
 }
 
 type tagData struct {
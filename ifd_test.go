@@ -0,0 +1,137 @@
+package cog
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flywave/go-geo"
+
+	vec2d "github.com/flywave/go3d/float64/vec2"
+)
+
+func TestGeoTransformPixelWorld(t *testing.T) {
+	gt := GeoTransform{1000, 2, 0.5, 5000, 0.25, -2}
+	x, y := gt.PixelToWorld(10, 20)
+	px, py := gt.WorldToPixel(x, y)
+	if math.Abs(px-10) > 1e-9 || math.Abs(py-20) > 1e-9 {
+		t.Fatalf("round trip failed: %v %v", px, py)
+	}
+
+	b := gt.Bounds(100, 100)
+	if b.Min[0] != 1000 || b.Max[0] != 1250 || b.Min[1] != 4800 || b.Max[1] != 5025 {
+		t.Fatalf("unexpected bounds %v", b)
+	}
+}
+
+func TestWriteRotatedTransform(t *testing.T) {
+	data := make([]float32, 16*16)
+	rect := image.Rect(0, 0, 16, 16)
+	src := NewSource(data, &rect, CTLZW)
+
+	gt := GeoTransform{116, 0.001, 0.0002, 40, 0.0002, -0.001}
+	w := NewTileWriter(src, tiffByteOrder, false, vec2d.Rect{}, geo.NewProj(4326), [2]uint32{16, 16}, nil)
+	w.SetTransform(gt)
+
+	name := filepath.Join(t.TempDir(), "rotated.tif")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteData(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r := Read(name)
+	if len(r.ifds[0].ModelTransformationTag) != 16 {
+		t.FailNow()
+	}
+	if r.GetGeoTransform(0) != gt {
+		t.Fatalf("got %v want %v", r.GetGeoTransform(0), gt)
+	}
+	if r.GetBounds(0) != gt.Bounds(16, 16) {
+		t.FailNow()
+	}
+}
+
+func TestSouthUpGeotransform(t *testing.T) {
+	ifd := &IFD{}
+	gt := GeoTransform{116, 0.001, 0, 39, 0, 0.001}
+	ifd.SetGeoTransform(gt)
+	if ifd.ModelPixelScaleTag[1] >= 0 {
+		t.FailNow()
+	}
+	read, err := ifd.Geotransform()
+	if err != nil || read != gt {
+		t.Fatalf("got %v %v", read, err)
+	}
+	b := read.Bounds(100, 100)
+	if b.Min[1] != 39 || math.Abs(b.Max[1]-39.1) > 1e-9 {
+		t.Fatalf("unexpected bounds %v", b)
+	}
+}
+
+func TestIFDStructure(t *testing.T) {
+	desc := "odd length"
+	ifd := &IFD{
+		ImageWidth:         100,
+		ImageLength:        100,
+		BitsPerSample:      []uint16{8, 8, 8},
+		Compression:        CTLZW,
+		SamplesPerPixel:    3,
+		TileWidth:          16,
+		TileLength:         16,
+		NewTileOffsets32:   []uint32{1, 2, 3},
+		TileByteCounts:     []uint32{4, 5, 6},
+		DocumentName:       "doc",
+		ImageDescription:   &desc,
+		GeoAsciiParamsTag:  "WGS84|",
+		ModelPixelScaleTag: []float64{1, 1, 0},
+		NoData:             "-9999",
+		ExtraTags:          []Tag{{ID: 300, Type: TypeASCII, Value: "x"}, {ID: 65000, Type: TypeByte, Value: []byte{1, 2, 3, 4, 5}}},
+	}
+	for _, bigtiff := range []bool{false, true} {
+		ifd.ntags, ifd.tagsSize, ifd.strileSize, ifd.nplanes = ifd.structure(bigtiff)
+		g := &Writer{bigtiff: bigtiff, enc: tiffByteOrder}
+		buf := &bytes.Buffer{}
+		strile := &tagData{Offset: 200 + ifd.tagsSize}
+		if err := g.writeIFD(buf, ifd, 200, strile, false); err != nil {
+			t.Fatal(err)
+		}
+		if uint64(buf.Len()) != ifd.tagsSize || uint64(strile.Len()) != ifd.strileSize {
+			t.Fatalf("wrote %d+%d bytes, structure computed %d+%d", buf.Len(), strile.Len(), ifd.tagsSize, ifd.strileSize)
+		}
+
+		data := buf.Bytes()
+		head, size, inline := 2, 12, 4
+		if bigtiff {
+			head, size, inline = 8, 20, 8
+		}
+		last := uint16(0)
+		for i := uint64(0); i < ifd.ntags; i++ {
+			e := data[head+int(i)*size:]
+			id := tiffByteOrder.Uint16(e)
+			if id <= last {
+				t.Fatalf("tag %d written after %d", id, last)
+			}
+			last = id
+			typeSize := uint64(tagTypeSize(tiffByteOrder.Uint16(e[2:])))
+			if typeSize == 0 {
+				t.Fatalf("tag %d has unknown type", id)
+			}
+			var count, off uint64
+			if bigtiff {
+				count, off = tiffByteOrder.Uint64(e[4:]), tiffByteOrder.Uint64(e[12:])
+			} else {
+				count, off = uint64(tiffByteOrder.Uint32(e[4:])), uint64(tiffByteOrder.Uint32(e[8:]))
+			}
+			if count*typeSize > uint64(inline) && off%2 != 0 {
+				t.Fatalf("tag %d value at odd offset %d", id, off)
+			}
+		}
+	}
+}
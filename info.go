@@ -0,0 +1,335 @@
+package cog
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/tiff"
+)
+
+type Info struct {
+	Description        string                       `json:"description"`
+	DriverShortName    string                       `json:"driverShortName"`
+	DriverLongName     string                       `json:"driverLongName"`
+	Size               [2]uint64                    `json:"size"`
+	GeoTransform       []float64                    `json:"geoTransform,omitempty"`
+	Metadata           map[string]map[string]string `json:"metadata"`
+	CornerCoordinates  *CornerCoordinates           `json:"cornerCoordinates,omitempty"`
+	Stac               map[string]interface{}       `json:"stac,omitempty"`
+	Bands              []BandInfo                   `json:"bands"`
+	GeoKeys            map[string]interface{}       `json:"geoKeys,omitempty"`
+	StructuralMetadata map[string]string            `json:"structuralMetadata,omitempty"`
+	IFDs               []IFDInfo                    `json:"ifds"`
+}
+
+type CornerCoordinates struct {
+	UpperLeft  [2]float64 `json:"upperLeft"`
+	LowerLeft  [2]float64 `json:"lowerLeft"`
+	LowerRight [2]float64 `json:"lowerRight"`
+	UpperRight [2]float64 `json:"upperRight"`
+	Center     [2]float64 `json:"center"`
+}
+
+type OverviewInfo struct {
+	Size [2]uint64 `json:"size"`
+}
+
+type BandInfo struct {
+	Band                int                          `json:"band"`
+	Block               [2]uint64                    `json:"block"`
+	Type                string                       `json:"type"`
+	Description         string                       `json:"description,omitempty"`
+	ColorInterpretation string                       `json:"colorInterpretation"`
+	Minimum             *float64                     `json:"minimum,omitempty"`
+	Maximum             *float64                     `json:"maximum,omitempty"`
+	Mean                *float64                     `json:"mean,omitempty"`
+	StdDev              *float64                     `json:"stdDev,omitempty"`
+	NoDataValue         interface{}                  `json:"noDataValue,omitempty"`
+	Offset              *float64                     `json:"offset,omitempty"`
+	Scale               *float64                     `json:"scale,omitempty"`
+	Unit                string                       `json:"unit,omitempty"`
+	Overviews           []OverviewInfo               `json:"overviews,omitempty"`
+	Metadata            map[string]map[string]string `json:"metadata,omitempty"`
+}
+
+type IFDInfo struct {
+	Index               int       `json:"index"`
+	Size                [2]uint64 `json:"size"`
+	Block               [2]uint64 `json:"block"`
+	Blocks              [2]uint64 `json:"blocks"`
+	Tiled               bool      `json:"tiled"`
+	Overview            bool      `json:"overview"`
+	Mask                bool      `json:"mask"`
+	Compression         string    `json:"compression"`
+	Photometric         string    `json:"photometric"`
+	Predictor           string    `json:"predictor,omitempty"`
+	PlanarConfiguration string    `json:"planarConfiguration,omitempty"`
+	SamplesPerPixel     uint16    `json:"samplesPerPixel"`
+	BitsPerSample       []uint16  `json:"bitsPerSample"`
+	SampleFormat        []string  `json:"sampleFormat,omitempty"`
+	NoData              string    `json:"noData,omitempty"`
+}
+
+func keyword(m map[uint]string, v uint) string {
+	if s, ok := m[v]; ok {
+		return s
+	}
+	return "Unknown"
+}
+
+func gdalDataType(bits uint16, format uint16) string {
+	return DataTypeOf(bits, format).String()
+}
+
+func colorInterpretation(ifd *IFD, band int) string {
+	switch ifd.PhotometricInterpretation {
+	case PI_BlackIsZero, PI_WhiteIsZero:
+		if band == 0 {
+			return "Gray"
+		}
+	case PI_Paletted:
+		if band == 0 {
+			return "Palette"
+		}
+	case PI_RGB:
+		if band < 3 {
+			return [...]string{"Red", "Green", "Blue"}[band]
+		}
+	case PI_CMYK:
+		if band < 4 {
+			return [...]string{"Cyan", "Magenta", "Yellow", "Black"}[band]
+		}
+	case PI_YCbCr:
+		if band < 3 {
+			return [...]string{"YCbCr_Y", "YCbCr_Cb", "YCbCr_Cr"}[band]
+		}
+	}
+	if band >= int(ifd.SamplesPerPixel)-len(ifd.ExtraSamples) {
+		e := ifd.ExtraSamples[band-(int(ifd.SamplesPerPixel)-len(ifd.ExtraSamples))]
+		if e == 1 || e == 2 {
+			return "Alpha"
+		}
+	}
+	return "Undefined"
+}
+
+func gdalCompression(c uint16) string {
+	switch c {
+	case CTNone:
+		return ""
+	case CTDeflate, CTDeflateOld:
+		return "DEFLATE"
+	}
+	return strings.ToUpper(keyword(CompressionMap, uint(c)))
+}
+
+func jsonFloat(v float64) interface{} {
+	if math.IsNaN(v) {
+		return "nan"
+	}
+	if math.IsInf(v, 1) {
+		return "inf"
+	}
+	if math.IsInf(v, -1) {
+		return "-inf"
+	}
+	return v
+}
+
+func describeGeoKey(key uint16, v interface{}) interface{} {
+	if code, ok := v.(uint16); ok {
+		if m, ok := KeywordMap[int(key)]; ok {
+			if s, ok := m[uint(code)]; ok {
+				return s
+			}
+		}
+		return code
+	}
+	if s, ok := v.(string); ok {
+		return strings.TrimRight(s, "|\x00")
+	}
+	return v
+}
+
+func ReadInfo(r tiff.ReadAtReadSeeker) (*Info, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tif, err := tiff.Parse(r, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	m := &Reader{}
+	for _, tifd := range tif.IFDs() {
+		ifd, err := loadIFD(tif.R(), tifd)
+		if err != nil {
+			return nil, err
+		}
+		m.ifds = append(m.ifds, ifd)
+	}
+	if len(m.ifds) == 0 {
+		return nil, errors.New("no IFD found")
+	}
+
+	info := &Info{
+		DriverShortName: "GTiff",
+		DriverLongName:  "GeoTIFF",
+		Metadata:        map[string]map[string]string{},
+	}
+
+	ghost, _, err := readGhost(r)
+	if err != nil {
+		return nil, err
+	}
+	info.StructuralMetadata = ghost
+
+	main := m.ifds[0]
+	info.Size = [2]uint64{main.ImageWidth, main.ImageLength}
+
+	md, err := main.Metadata()
+	if err != nil {
+		md = NewGDALMetadata()
+	}
+	for domain, items := range md.Items {
+		info.Metadata[domain] = items
+	}
+	structure := map[string]string{}
+	if c := gdalCompression(main.Compression); c != "" {
+		structure["COMPRESSION"] = c
+	}
+	if main.SamplesPerPixel > 1 {
+		if main.PlanarConfiguration == 2 {
+			structure["INTERLEAVE"] = "BAND"
+		} else {
+			structure["INTERLEAVE"] = "PIXEL"
+		}
+	}
+	if len(main.BitsPerSample) > 0 && main.BitsPerSample[0] < 8 {
+		structure["NBITS"] = strconv.Itoa(int(main.BitsPerSample[0]))
+	}
+	if ghost["LAYOUT"] == "IFDS_BEFORE_DATA" {
+		structure["LAYOUT"] = "COG"
+	}
+	if len(structure) > 0 {
+		info.Metadata["IMAGE_STRUCTURE"] = structure
+	}
+
+	if gt, err := main.Geotransform(); err == nil {
+		info.GeoTransform = gt[:]
+		w, h := float64(main.ImageWidth), float64(main.ImageLength)
+		corner := func(px, py float64) [2]float64 {
+			x, y := gt.PixelToWorld(px, py)
+			return [2]float64{x, y}
+		}
+		info.CornerCoordinates = &CornerCoordinates{
+			UpperLeft:  corner(0, 0),
+			LowerLeft:  corner(0, h),
+			LowerRight: corner(w, h),
+			UpperRight: corner(w, 0),
+			Center:     corner(w/2, h/2),
+		}
+	}
+
+	if keys, err := m.parseGeoKeys(0); err == nil && len(keys) > 0 {
+		info.GeoKeys = make(map[string]interface{})
+		for k, v := range keys {
+			name := keyword(GeoKeyMap, uint(k))
+			info.GeoKeys[name] = describeGeoKey(k, v)
+		}
+		if code, err := m.GetEPSGCode(0); err == nil && code != 0 {
+			info.Stac = map[string]interface{}{
+				"proj:epsg":  code,
+				"proj:shape": [2]uint64{main.ImageLength, main.ImageWidth},
+			}
+		}
+	}
+
+	var overviews []OverviewInfo
+	for i, ifd := range m.ifds {
+		ii := IFDInfo{
+			Index:           i,
+			Size:            [2]uint64{ifd.ImageWidth, ifd.ImageLength},
+			Tiled:           ifd.TileWidth > 0,
+			Overview:        ifd.NewSubfileType&subfileReduced != 0,
+			Mask:            ifd.NewSubfileType&subfileMask != 0,
+			Compression:     keyword(CompressionMap, uint(ifd.Compression)),
+			Photometric:     keyword(PhotometricMap, uint(ifd.PhotometricInterpretation)),
+			SamplesPerPixel: ifd.SamplesPerPixel,
+			BitsPerSample:   ifd.BitsPerSample,
+			NoData:          ifd.NoData,
+		}
+		if ii.Tiled {
+			ii.Block = [2]uint64{uint64(ifd.TileWidth), uint64(ifd.TileLength)}
+		} else {
+			rows := ifd.ImageLength
+			if ifd.RowsPerStrip != nil && uint64(*ifd.RowsPerStrip) < rows {
+				rows = uint64(*ifd.RowsPerStrip)
+			}
+			ii.Block = [2]uint64{ifd.ImageWidth, rows}
+		}
+		if ii.Block[0] > 0 && ii.Block[1] > 0 {
+			ii.Blocks = [2]uint64{(ifd.ImageWidth + ii.Block[0] - 1) / ii.Block[0], (ifd.ImageLength + ii.Block[1] - 1) / ii.Block[1]}
+		}
+		if ifd.Predictor > 0 {
+			ii.Predictor = keyword(PredictorMap, uint(ifd.Predictor))
+		}
+		if ifd.PlanarConfiguration > 0 {
+			ii.PlanarConfiguration = keyword(PlanarConfiguationMap, uint(ifd.PlanarConfiguration))
+		}
+		for _, f := range ifd.SampleFormat {
+			ii.SampleFormat = append(ii.SampleFormat, keyword(SampleFormatMap, uint(f)))
+		}
+		info.IFDs = append(info.IFDs, ii)
+		if i > 0 && ii.Overview && !ii.Mask {
+			overviews = append(overviews, OverviewInfo{Size: ii.Size})
+		}
+	}
+
+	spp := int(main.SamplesPerPixel)
+	if spp == 0 {
+		spp = 1
+	}
+	for b := 0; b < spp; b++ {
+		bits, format := uint16(8), uint16(1)
+		if b < len(main.BitsPerSample) {
+			bits = main.BitsPerSample[b]
+		} else if len(main.BitsPerSample) > 0 {
+			bits = main.BitsPerSample[0]
+		}
+		if b < len(main.SampleFormat) {
+			format = main.SampleFormat[b]
+		} else if len(main.SampleFormat) > 0 {
+			format = main.SampleFormat[0]
+		}
+		band := BandInfo{
+			Band:                b + 1,
+			Block:               info.IFDs[0].Block,
+			Type:                gdalDataType(bits, format),
+			ColorInterpretation: colorInterpretation(main, b),
+			Overviews:           overviews,
+		}
+		if nd := m.GetNoData(0); nd != nil {
+			band.NoDataValue = jsonFloat(*nd)
+		}
+		if b < len(md.Bands) {
+			bm := md.Bands[b]
+			band.Description = bm.Description
+			band.Offset, band.Scale, band.Unit = bm.Offset, bm.Scale, bm.Unit
+			if bm.ColorInterp != "" {
+				band.ColorInterpretation = bm.ColorInterp
+			}
+			if st := bm.Statistics; st != nil {
+				band.Minimum, band.Maximum, band.Mean, band.StdDev = &st.Minimum, &st.Maximum, &st.Mean, &st.StdDev
+			}
+			if len(bm.Items) > 0 {
+				band.Metadata = bm.Items
+			}
+		}
+		info.Bands = append(info.Bands, band)
+	}
+
+	return info, nil
+}
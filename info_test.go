@@ -0,0 +1,62 @@
+package cog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestReadInfo(t *testing.T) {
+	f, err := os.Open("./test_data/cog_ext_multi.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	info, err := ReadInfo(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != [2]uint64{256, 256} || len(info.IFDs) != 3 || len(info.Bands) != 1 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if info.Bands[0].Type != "Byte" || len(info.Bands[0].Overviews) != 2 {
+		t.Fatalf("unexpected band %+v", info.Bands[0])
+	}
+	if info.Metadata["IMAGE_STRUCTURE"]["LAYOUT"] != "COG" || info.IFDs[1].Compression != "LZW" {
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	opts := &Options{Metadata: map[string]string{"AREA_OR_POINT": "Area"}}
+	if err := WriteTo(buf, []*TileLayer{buildTestLayer(t)}, opts); err != nil {
+		t.Fatal(err)
+	}
+	info, err = ReadInfo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Metadata[""]["AREA_OR_POINT"] != "Area" || info.Stac["proj:epsg"] != 3857 || info.CornerCoordinates == nil {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if info.GeoKeys["ProjectedCSTypeGeoKey"] == nil {
+		t.Fatalf("geokeys %v", info.GeoKeys)
+	}
+	if _, err := json.Marshal(info); err != nil {
+		t.Fatal(err)
+	}
+
+	big, err := os.Open("./test_data/cog_bigtiff.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer big.Close()
+	info, err = ReadInfo(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != [2]uint64{128, 128} || len(info.Bands) != 1 || info.Bands[0].Type != "UInt16" {
+		t.Fatalf("unexpected bigtiff info %+v", info)
+	}
+}
@@ -3,10 +3,8 @@ package cog
 import (
 	"encoding/binary"
 	"errors"
-	"image"
 	"io"
 	"io/ioutil"
-	"os"
 	"sort"
 
 	"github.com/flywave/go-geo"
@@ -37,23 +35,25 @@ func (a tiledSortedByXYup) Len() int      { return len(a) }
 func (a tiledSortedByXYup) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 func (a tiledSortedByXYup) Less(i, j int) bool {
 	if a[i].Id[1] == a[j].Id[1] {
-		return a[i].Id[0] > a[j].Id[0]
+		return a[i].Id[0] < a[j].Id[0]
 	}
-	return a[i].Id[1] < a[j].Id[1]
+	return a[i].Id[1] > a[j].Id[1]
 }
 
 type TileLayer struct {
-	row      int
-	col      int
-	level    int
-	size     [2]int
-	tiles    []*Tile
-	tilemap  map[[3]int]*Tile
-	box      vec2d.Rect
-	grid     *geo.TileGrid
-	ifd      *IFD
-	tempFile *os.File
-	noData   *string
+	row     int
+	col     int
+	level   int
+	size    [2]int
+	tiles   []*Tile
+	tilemap map[[3]int]*Tile
+	box     vec2d.Rect
+	grid    *geo.TileGrid
+	ifd     *IFD
+	spool   Spool
+	noData  *float64
+	workers int
+	sparse  bool
 }
 
 func NewTileLayer(box vec2d.Rect, level int, grid *geo.TileGrid) *TileLayer {
@@ -87,24 +87,17 @@ func NewTileLayer(box vec2d.Rect, level int, grid *geo.TileGrid) *TileLayer {
 		tiles[i].block = [2]int{int(i % si[0]), int(i / si[0])}
 	}
 
-	p, err := ioutil.TempFile(os.TempDir(), "tile-")
-
-	if err != nil {
-		return nil
-	}
-
 	imagesi := [2]int{int(grid.TileSize[0]) * si[0], int(grid.TileSize[1]) * si[1]}
 
 	return &TileLayer{
-		row:      si[1],
-		col:      si[0],
-		level:    level,
-		size:     imagesi,
-		box:      rect,
-		grid:     grid,
-		tilemap:  tilemap,
-		tiles:    tiles,
-		tempFile: p,
+		row:     si[1],
+		col:     si[0],
+		level:   level,
+		size:    imagesi,
+		box:     rect,
+		grid:    grid,
+		tilemap: tilemap,
+		tiles:   tiles,
 	}
 }
 
@@ -119,7 +112,7 @@ func (l *TileLayer) GetTile(t [3]int) *Tile {
 }
 
 func (l *TileLayer) GetReader() io.ReadSeeker {
-	return l.tempFile
+	return l.spool
 }
 
 func (l *TileLayer) SetSource(t [3]int, src TileSource) error {
@@ -137,19 +130,34 @@ func (l *TileLayer) GetTileSize() [2]uint32 {
 	return [2]uint32{l.grid.TileSize[0], l.grid.TileSize[1]}
 }
 
+func (l *TileLayer) srs() (uint, geo.Proj) {
+	code := geo.GetEpsgNum(l.grid.Srs.GetSrsCode())
+	if code == 900913 {
+		code = 3857
+	}
+	if code > 0 {
+		if _, ok := GeographicTypeMap[uint(code)]; ok {
+			return uint(code), l.grid.Srs
+		}
+		if _, ok := ProjectedCSMap[uint(code)]; ok {
+			return uint(code), l.grid.Srs
+		}
+	}
+	return 4326, epsg4326
+}
+
 func (l *TileLayer) GetTransform() GeoTransform {
-	box := l.grid.Srs.TransformRectTo(epsg4326, l.box, 16)
+	_, srs := l.srs()
+	box := l.grid.Srs.TransformRectTo(srs, l.box, 16)
 
 	res := caclulatePixelSize(l.size[0], l.size[1], box)
 
-	if l.grid.FlippedYAxis {
-		return GeoTransform{box.Min[0], res[0], 0, box.Max[1], 0, -res[1]}
-	}
-	return GeoTransform{box.Min[0], res[0], 0, box.Max[1], 0, res[1]}
+	return GeoTransform{box.Min[0], res[0], 0, box.Max[1], 0, -res[1]}
 }
 
 func (l *TileLayer) setupIFD() {
-	l.ifd.SetEPSG(uint(4326), true)
+	epsg, _ := l.srs()
+	l.ifd.SetEPSG(epsg, true)
 
 	l.ifd.ImageWidth, l.ifd.ImageLength = uint64(l.size[0]), uint64(l.size[1])
 
@@ -161,25 +169,9 @@ func (l *TileLayer) setupIFD() {
 		l.ifd.TileLength = uint16(l.grid.TileSize[1])
 	}
 
-	if l.ifd.TileWidth != uint16(l.size[0]) {
-		l.ifd.TileWidth = uint16(l.size[0])
-	}
-
-	if l.ifd.TileLength != uint16(l.size[1]) {
-		l.ifd.TileLength = uint16(l.size[1])
-	}
-
-	box := l.grid.Srs.TransformRectTo(epsg4326, l.box, 16)
-
-	cellSizeX := (box.Max[0] - box.Min[0]) / float64(l.size[0])
-	cellSizeY := (box.Max[1] - box.Min[1]) / float64(l.size[1])
+	l.ifd.SetGeoTransform(l.GetTransform())
 
-	l.ifd.ModelTiePointTag = []float64{0, 0, 0, box.Min[0], box.Max[1], 0}
-	l.ifd.ModelPixelScaleTag = []float64{cellSizeX, cellSizeY, 0}
-
-	if l.noData != nil {
-		l.ifd.NoData = *l.noData
-	}
+	l.ifd.SetNoData(l.noData)
 }
 
 func (l *TileLayer) Valid() bool {
@@ -191,69 +183,155 @@ func (l *TileLayer) Valid() bool {
 	return true
 }
 
-func (l *TileLayer) processEmpty() {
-	var zero interface{}
-	var rect image.Rectangle
-	var ctype CompressionType
+func (l *TileLayer) SetNoData(v *float64) {
+	l.noData = v
+}
+
+func (l *TileLayer) GetNoData() *float64 {
+	return l.noData
+}
+
+func (l *TileLayer) processEmpty() error {
+	var like TileSource
 	for i := range l.tiles {
-		if zero == nil && l.tiles[i].Src != nil {
-			zero = getZeroDate(l.tiles[i].Src)
-			rect = l.tiles[i].Src.Bounds()
-			ctype = l.tiles[i].Src.CompressionType()
+		if l.tiles[i].Src != nil {
+			like = l.tiles[i].Src
 			break
 		}
 	}
+	if like == nil {
+		return nil
+	}
+	empty, err := emptyPixelData(like, l.noData)
+	if err != nil {
+		return err
+	}
+	rect := like.Bounds()
 	for i := range l.tiles {
 		if l.tiles[i].Src == nil {
-			l.tiles[i].Src = NewSource(zero, &rect, ctype)
+			l.tiles[i].Src = NewSource(empty, &rect, like.CompressionType())
 		}
 	}
+	return nil
 }
 
 func (l *TileLayer) Close() error {
-	l.tempFile.Close()
-	os.Remove(l.tempFile.Name())
-	return nil
+	if l.spool == nil {
+		return nil
+	}
+	err := l.spool.Close()
+	l.spool = nil
+	return err
 }
 
-func (l *TileLayer) encode(enc binary.ByteOrder, clearOnSave bool) error {
-	if !l.Valid() {
-		l.processEmpty()
+func (l *TileLayer) encode(enc binary.ByteOrder, spool SpoolFactory, clearOnSave bool) error {
+	if !l.Valid() && !l.sparse {
+		if err := l.processEmpty(); err != nil {
+			return err
+		}
 	}
 
-	offset := uint64(0)
-
-	for i := range l.tiles {
-		var imageLen uint32
-		if l.ifd == nil {
-			l.ifd = &IFD{
-				OriginalTileOffsets: make([]uint64, len(l.tiles)),
-				TileByteCounts:      make([]uint32, len(l.tiles)),
+	var out io.Writer
+	if spool != nil {
+		if l.spool == nil {
+			s, err := spool()
+			if err != nil {
+				return err
 			}
+			l.spool = s
 		}
-		n, _, err := l.tiles[i].Src.Encode(l.tempFile, l.ifd)
-		if err != nil {
+		if _, err := l.spool.Seek(0, io.SeekStart); err != nil {
 			return err
 		}
-		imageLen = n
-		l.ifd.TileByteCounts[i] = imageLen
-		l.ifd.OriginalTileOffsets[i] = offset
-		offset += uint64(imageLen + 8)
+		out = l.spool
+	} else {
+		out = &countingWriter{}
+		clearOnSave = false
 	}
 
-	l.tempFile.Sync()
+	for _, t := range l.tiles {
+		setSourceByteOrder(t.Src, enc)
+	}
 
-	l.setupIFD()
+	if l.ifd == nil {
+		l.ifd = &IFD{
+			OriginalTileOffsets: make([]uint64, len(l.tiles)),
+			TileByteCounts:      make([]uint32, len(l.tiles)),
+		}
+	}
+
+	if l.sparse {
+		if err := l.describeTiles(); err != nil {
+			return err
+		}
+	}
 
-	if clearOnSave {
-		for i := range l.tiles {
+	offset := uint64(0)
+
+	err := encodeTiles(l.tiles, l.concurrency(), l.skipTile, func(i int, r *encodeResult) error {
+		if !r.skip {
+			applyTileIFD(l.ifd, &r.ifd)
+		}
+		l.ifd.TileByteCounts[i] = r.n
+		l.ifd.OriginalTileOffsets[i] = offset
+		if r.n > 0 {
+			if err := writeBlock(out, enc, r.buf.Bytes()); err != nil {
+				return err
+			}
+			offset += uint64(r.n + 8)
+		}
+		if clearOnSave && l.tiles[i].Src != nil {
 			l.tiles[i].Src.Reset()
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
+	l.setupIFD()
+
 	return nil
 }
 
+func (l *TileLayer) SetConcurrency(n int) {
+	l.workers = n
+}
+
+func (l *TileLayer) SetSparse(sparse bool) {
+	l.sparse = sparse
+}
+
+func (l *TileLayer) skipTile(i int) bool {
+	src := l.tiles[i].Src
+	if src == nil {
+		return true
+	}
+	return l.sparse && emptyTile(src, l.noData)
+}
+
+func (l *TileLayer) describeTiles() error {
+	for _, t := range l.tiles {
+		if t.Src == nil {
+			continue
+		}
+		var ifd IFD
+		if _, _, err := t.Src.Encode(ioutil.Discard, &ifd); err != nil {
+			return err
+		}
+		applyTileIFD(l.ifd, &ifd)
+		return nil
+	}
+	return errors.New("layer has no tile data")
+}
+
+func (l *TileLayer) concurrency() int {
+	if l.workers > 0 {
+		return l.workers
+	}
+	return defaultConcurrency()
+}
+
 type layerSorted []*TileLayer
 
 func (a layerSorted) Len() int      { return len(a) }
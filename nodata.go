@@ -0,0 +1,196 @@
+package cog
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func formatNoData(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "nan"
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	case v == math.Trunc(v) && math.Abs(v) < 1e18:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func parseNoData(s string) *float64 {
+	s = strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	if s == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+func isNoData(noData *float64, v float64) bool {
+	if noData == nil {
+		return false
+	}
+	if math.IsNaN(*noData) {
+		return math.IsNaN(v)
+	}
+	return v == *noData
+}
+
+func (ifd *IFD) GetNoData() *float64 {
+	return parseNoData(ifd.NoData)
+}
+
+func (ifd *IFD) SetNoData(v *float64) {
+	if v == nil {
+		ifd.NoData = ""
+		return
+	}
+	ifd.NoData = formatNoData(*v)
+}
+
+func fillPixelData(data *Raster, width, height int, v float64) error {
+	p, err := newPixelBuffer(data, width, height)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < width*height; i++ {
+		for b := 0; b < p.bands; b++ {
+			p.set(i, b, v)
+		}
+	}
+	return nil
+}
+
+func emptyPixelData(like TileSource, noData *float64) (*Raster, error) {
+	w, h := like.Bounds().Dx(), like.Bounds().Dy()
+	if like.Data() == nil {
+		return nil, errors.New("source has no data")
+	}
+	data := makePixelData(like.Data(), w, h)
+	if noData != nil && *noData != 0 {
+		if err := fillPixelData(data, w, h, *noData); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// emptyTile reports whether every sample of src equals noData, or zero when
+// no nodata value is set, so that a sparse layer can omit it.
+func emptyTile(src TileSource, noData *float64) bool {
+	size := src.Bounds().Size()
+	p, err := newPixelBuffer(src.Data(), size.X, size.Y)
+	if err != nil {
+		return false
+	}
+	empty := 0.0
+	if noData != nil {
+		empty = *noData
+	}
+	for i := 0; i < size.X*size.Y; i++ {
+		for b := 0; b < p.bands; b++ {
+			if !isNoData(&empty, p.get(i, b)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// emptyBlock returns the uncompressed content of a block that has no data
+// in the file, filled with the nodata value of the IFD.
+func (ifd *IFD) emptyBlock(width, height int) []byte {
+	spp, bits, format := len(ifd.BitsPerSample), 8, uint16(1)
+	if spp == 0 {
+		spp = 1
+	} else {
+		bits = int(ifd.BitsPerSample[0])
+	}
+	if len(ifd.SampleFormat) > 0 {
+		format = ifd.SampleFormat[0]
+	}
+	if bits < 8 {
+		return make([]byte, (width*spp*bits+7)/8*height)
+	}
+	size := bits / 8
+	buf := make([]byte, width*height*spp*size)
+	nd := ifd.GetNoData()
+	if nd == nil || *nd == 0 {
+		return buf
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if ifd.r != nil {
+		order = ifd.r.ByteOrder()
+	}
+	// complex samples hold the value in both the real and imaginary part
+	part := size
+	if format == SampleFormatComplexInt || format == SampleFormatComplexIEEEFP {
+		part = size / 2
+	}
+	sample := make([]byte, size)
+	for o := 0; o < size; o += part {
+		putSample(sample[o:], order, format, part, *nd)
+	}
+	for i := 0; i < len(buf); i += size {
+		copy(buf[i:], sample)
+	}
+	return buf
+}
+
+func (m Reader) maskIndex(i int) int {
+	if i+1 < len(m.ifds) && m.ifds[i+1].NewSubfileType&subfileMask != 0 &&
+		m.ifds[i+1].ImageWidth == m.ifds[i].ImageWidth && m.ifds[i+1].ImageLength == m.ifds[i].ImageLength {
+		return i + 1
+	}
+	return -1
+}
+
+// GetValidMask returns 255 for valid and 0 for missing pixels of image i.
+// An internal mask IFD takes precedence, otherwise a pixel is missing when
+// all of its bands hold the nodata value (or NaN for floating point data).
+func (m Reader) GetValidMask(i int) (*image.Alpha, error) {
+	size := m.GetSize(i)
+	w, h := int(size[0]), int(size[1])
+	mask := image.NewAlpha(image.Rect(0, 0, w, h))
+
+	if j := m.maskIndex(i); j >= 0 && j < len(m.Data) && m.Data[j] != nil {
+		p, err := newPixelBuffer(m.Data[j], w, h)
+		if err != nil {
+			return nil, err
+		}
+		for k := 0; k < w*h; k++ {
+			if p.get(k, 0) != 0 {
+				mask.Pix[k] = 0xff
+			}
+		}
+		return mask, nil
+	}
+
+	if i >= len(m.Data) || m.Data[i] == nil {
+		return nil, errors.New("image data not loaded")
+	}
+	p, err := newPixelBuffer(m.Data[i], w, h)
+	if err != nil {
+		return nil, err
+	}
+	noData := m.GetNoData(i)
+	for k := 0; k < w*h; k++ {
+		for b := 0; b < p.bands; b++ {
+			if v := p.get(k, b); !math.IsNaN(v) && !isNoData(noData, v) {
+				mask.Pix[k] = 0xff
+				break
+			}
+		}
+	}
+	return mask, nil
+}
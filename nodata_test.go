@@ -0,0 +1,143 @@
+package cog
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestNoDataFormat(t *testing.T) {
+	for v, s := range map[float64]string{-9999: "-9999", 1e6: "1000000", 0.5: "0.5", math.Inf(-1): "-inf"} {
+		if got := formatNoData(v); got != s {
+			t.Fatalf("format %v: got %q want %q", v, got, s)
+		}
+		if got := parseNoData(s); got == nil || *got != v {
+			t.Fatalf("parse %q: got %v", s, got)
+		}
+	}
+	if v := parseNoData("nan"); v == nil || !math.IsNaN(*v) || formatNoData(*v) != "nan" {
+		t.FailNow()
+	}
+	if parseNoData("") != nil || parseNoData("none") != nil {
+		t.FailNow()
+	}
+}
+
+func TestLayerNoDataFill(t *testing.T) {
+	base := buildTestLayer(t)
+	rect := image.Rect(0, 0, 512, 512)
+	data := make([]float32, 512*512)
+	for i := range data {
+		data[i] = 7
+	}
+
+	for _, nd := range []float64{math.NaN(), -9999} {
+		layer := NewTileLayer(base.box, 14, base.grid)
+		if err := layer.SetSource(layer.tiles[0].Id, NewSource(data, &rect, CTLZW)); err != nil {
+			t.Fatal(err)
+		}
+		nd := nd
+		layer.SetNoData(&nd)
+
+		buf := &bytes.Buffer{}
+		if err := WriteTo(buf, []*TileLayer{layer}, nil); err != nil {
+			t.Fatal(err)
+		}
+		r := ReadFrom(bytes.NewReader(buf.Bytes()))
+		if got := r.GetNoData(0); got == nil || !isNoData(got, nd) {
+			t.Fatalf("nodata %v, want %v", got, nd)
+		}
+		d, _ := r.Data[0].Data()
+		pix, ok := d.([]float32)
+		if !ok {
+			t.Fatalf("unexpected data type %T", d)
+		}
+		mask, err := r.GetValidMask(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := r.GetSize(0)
+		last := int(size[0]*size[1]) - 1
+		if pix[0] != 7 || mask.Pix[0] != 0xff {
+			t.Fatalf("first tile lost: %v %v", pix[0], mask.Pix[0])
+		}
+		if !isNoData(&nd, float64(pix[last])) || mask.Pix[last] != 0 {
+			t.Fatalf("missing tile filled with %v, mask %v", pix[last], mask.Pix[last])
+		}
+	}
+}
+
+func TestSparseLayer(t *testing.T) {
+	base := buildTestLayer(t)
+	rect := image.Rect(0, 0, 512, 512)
+	data := make([]int16, 512*512)
+	empty := make([]int16, 512*512)
+	for i := range data {
+		data[i] = 7
+		empty[i] = -9999
+	}
+
+	layer := NewTileLayer(base.box, 14, base.grid)
+	if len(layer.tiles) < 3 {
+		t.Skip("layer too small")
+	}
+	if err := layer.SetSource(layer.tiles[0].Id, NewSource(data, &rect, CTDeflate)); err != nil {
+		t.Fatal(err)
+	}
+	if err := layer.SetSource(layer.tiles[1].Id, NewSource(empty, &rect, CTDeflate)); err != nil {
+		t.Fatal(err)
+	}
+	nd := -9999.0
+	layer.SetNoData(&nd)
+	layer.SetSparse(true)
+
+	buf := &bytes.Buffer{}
+	if err := WriteTo(buf, []*TileLayer{layer}, nil); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Validate(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("errors %v", report.Errors)
+	}
+	if err := VerifyBlocks(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	r := ReadFrom(bytes.NewReader(buf.Bytes()))
+	ifd := r.ifds[0]
+	zero := 0
+	for i, n := range ifd.TileByteCounts {
+		if n == 0 {
+			if ifd.OriginalTileOffsets[i] != 0 {
+				t.Fatalf("tile %d has offset %d but no data", i, ifd.OriginalTileOffsets[i])
+			}
+			zero++
+		}
+	}
+	if zero != len(ifd.TileByteCounts)-1 {
+		t.Fatalf("%d of %d tiles empty", zero, len(ifd.TileByteCounts))
+	}
+
+	d, _ := r.Data[0].Data()
+	pix, ok := d.([]int16)
+	if !ok {
+		t.Fatalf("unexpected data type %T", d)
+	}
+	valid := 0
+	for _, v := range pix {
+		switch v {
+		case 7:
+			valid++
+		case -9999:
+		default:
+			t.Fatalf("unexpected value %d", v)
+		}
+	}
+	if valid != 512*512 {
+		t.Fatalf("%d valid pixels", valid)
+	}
+}
@@ -0,0 +1,148 @@
+package cog
+
+import (
+	"encoding/binary"
+)
+
+type Options struct {
+	BigTiff      bool
+	ByteOrder    binary.ByteOrder
+	Compression  CompressionType
+	Predictor    Predictor
+	Metadata     map[string]string
+	GDALMetadata *GDALMetadata
+	NoData       *float64
+	Spool        SpoolFactory
+	Concurrency  int
+	Tags         []Tag
+	Sparse       bool
+	Quantize     *Quantization
+
+	Statistics       bool
+	ApproxStatistics bool
+}
+
+func (o *Options) byteOrder() binary.ByteOrder {
+	if o == nil || o.ByteOrder == nil {
+		return tiffByteOrder
+	}
+	return o.ByteOrder
+}
+
+func setSourceByteOrder(src TileSource, enc binary.ByteOrder) {
+	if s, ok := src.(interface{ SetByteOrder(binary.ByteOrder) }); ok {
+		s.SetByteOrder(enc)
+	}
+}
+
+func (o *Options) applySource(src TileSource) {
+	if o == nil || src == nil {
+		return
+	}
+	if s, ok := src.(interface{ SetCompressionType(CompressionType) }); ok && o.Compression != 0 {
+		s.SetCompressionType(o.Compression)
+	}
+	if s, ok := src.(interface{ SetPredictor(Predictor) }); ok && o.Predictor != 0 {
+		s.SetPredictor(o.Predictor)
+	}
+}
+
+func (o *Options) applyLayer(l *TileLayer) {
+	if o == nil {
+		return
+	}
+	for _, t := range l.tiles {
+		t.Src = o.quantize(t.Src)
+		o.applySource(t.Src)
+	}
+	if o.NoData != nil {
+		l.noData = o.NoData
+	}
+	if o.Concurrency > 0 {
+		l.SetConcurrency(o.Concurrency)
+	}
+	if o.Sparse {
+		l.SetSparse(true)
+	}
+}
+
+func (o *Options) applyIFD(ifd *IFD) error {
+	if o == nil {
+		return nil
+	}
+	md := o.metadata()
+	if o.Quantize != nil {
+		o.Quantize.apply(md, int(ifd.SamplesPerPixel))
+	}
+	if !md.Empty() {
+		if err := ifd.SetMetadata(md); err != nil {
+			return err
+		}
+	}
+	if o.NoData != nil {
+		ifd.SetNoData(o.NoData)
+	}
+	for _, t := range o.Tags {
+		if err := ifd.SetTag(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Options) statisticsLayer(layers []*TileLayer) *TileLayer {
+	if o == nil || len(layers) == 0 {
+		return nil
+	}
+	if o.ApproxStatistics {
+		return layers[len(layers)-1]
+	}
+	if o.Statistics {
+		return layers[0]
+	}
+	return nil
+}
+
+func (o *Options) quantize(src TileSource) TileSource {
+	if o == nil || o.Quantize == nil || src == nil {
+		return src
+	}
+	if _, ok := src.(*quantizedSource); ok {
+		return src
+	}
+	return newQuantizedSource(src, *o.Quantize, o.NoData)
+}
+
+func (o *Options) checkTags() error {
+	if o == nil {
+		return nil
+	}
+	if o.Quantize != nil {
+		if err := o.Quantize.check(o.NoData); err != nil {
+			return err
+		}
+	}
+	var ifd IFD
+	for _, t := range o.Tags {
+		if err := ifd.SetTag(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Options) metadata() *GDALMetadata {
+	md := NewGDALMetadata()
+	if o.GDALMetadata != nil {
+		for d, items := range o.GDALMetadata.Items {
+			for k, v := range items {
+				md.Set(d, k, v)
+			}
+		}
+		md.Bands = append([]BandMetadata(nil), o.GDALMetadata.Bands...)
+	}
+	for k, v := range o.Metadata {
+		md.Set("", k, v)
+	}
+	return md
+}
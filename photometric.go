@@ -0,0 +1,227 @@
+package cog
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+func (ifd *IFD) ycbcrSubsampling() (int, int) {
+	if len(ifd.YCbCrSubSampling) < 2 {
+		return 2, 2
+	}
+	return int(ifd.YCbCrSubSampling[0]), int(ifd.YCbCrSubSampling[1])
+}
+
+func ycbcrRatio(h, v int) (image.YCbCrSubsampleRatio, error) {
+	switch {
+	case h == 1 && v == 1:
+		return image.YCbCrSubsampleRatio444, nil
+	case h == 2 && v == 1:
+		return image.YCbCrSubsampleRatio422, nil
+	case h == 2 && v == 2:
+		return image.YCbCrSubsampleRatio420, nil
+	case h == 1 && v == 2:
+		return image.YCbCrSubsampleRatio440, nil
+	case h == 4 && v == 1:
+		return image.YCbCrSubsampleRatio411, nil
+	case h == 4 && v == 2:
+		return image.YCbCrSubsampleRatio410, nil
+	}
+	return 0, errors.New("unsupported YCbCr subsampling")
+}
+
+// newRaster returns an empty raster laid out for the samples and
+// PhotometricInterpretation of ifd.
+func (ifd *IFD) newRaster() (*Raster, error) {
+	bits := ifd.BitsPerSample
+	if len(bits) == 0 {
+		bits = []uint16{1}
+	}
+	for _, b := range bits {
+		if b != bits[0] {
+			return nil, errors.New("bands with different bits per sample")
+		}
+	}
+	format := uint16(SampleFormatUInt)
+	if len(ifd.SampleFormat) > 0 {
+		format = ifd.SampleFormat[0]
+	}
+	r := &Raster{Width: int(ifd.ImageWidth), Height: int(ifd.ImageLength), Bands: len(bits), Type: DataTypeOf(bits[0], format)}
+	if r.Type == DTUnknown {
+		return nil, errors.New("unsupported data format")
+	}
+
+	switch ifd.PhotometricInterpretation {
+	case PI_WhiteIsZero, PI_BlackIsZero:
+	case PI_RGB:
+		if bits[0] != 8 && bits[0] != 16 {
+			return nil, fmt.Errorf("unsupported %d bit RGB", bits[0])
+		}
+		switch r.Bands {
+		case 3:
+		case 4:
+			es := uint16(0)
+			if len(ifd.ExtraSamples) > 0 {
+				es = ifd.ExtraSamples[0]
+			}
+			switch es {
+			case 1:
+				r.Color = ColorPremultiplied
+			case 2:
+			default:
+				return nil, errors.New("wrong number of samples for RGB")
+			}
+		default:
+			return nil, errors.New("wrong number of samples for RGB")
+		}
+	case PI_Paletted:
+		if r.Bands != 1 || bits[0] > 8 {
+			return nil, errors.New("unsupported paletted format")
+		}
+		val := ifd.Colormap
+		if len(val) == 0 {
+			return nil, errors.New("could not locate the colour map tag")
+		}
+		numcolors := len(val) / 3
+		if len(val)%3 != 0 || numcolors <= 0 || numcolors > 256 {
+			return nil, errors.New("bad ColorMap length")
+		}
+		r.Color = ColorPaletted
+		r.Palette = make(color.Palette, numcolors)
+		for i := 0; i < numcolors; i++ {
+			red := uint8(float64(val[i]) / 65535.0 * 255.0)
+			green := uint8(float64(val[i+numcolors]) / 65535.0 * 255.0)
+			blue := uint8(float64(val[i+2*numcolors]) / 65535.0 * 255.0)
+			r.Palette[i] = color.RGBA{R: red, G: green, B: blue, A: 255}
+		}
+	case PI_CMYK:
+		if r.Bands != 4 || bits[0] != 8 || ifd.InkSet > 1 {
+			return nil, errors.New("unsupported CMYK format")
+		}
+		r.Color = ColorCMYK
+	case PI_YCbCr:
+		if r.Bands != 3 || bits[0] != 8 {
+			return nil, errors.New("unsupported YCbCr format")
+		}
+		h, v := ifd.ycbcrSubsampling()
+		if _, err := ycbcrRatio(h, v); err != nil {
+			return nil, err
+		}
+		r.Color, r.Subsampling = ColorYCbCr, [2]int{h, v}
+	case PI_CIELab:
+		if r.Bands != 3 || bits[0] != 8 {
+			return nil, errors.New("unsupported CIELab format")
+		}
+	default:
+		return nil, errors.New("unsupported image format")
+	}
+	r.Pix = make([]byte, r.Width*r.Height*r.Bands*r.Type.Size())
+	return r, nil
+}
+
+// decodeYCbCr expands a block of YCbCr data units to width*height pixels
+// of Y, Cb and Cr. Each unit holds h*v luma samples followed by one Cb and
+// one Cr sample.
+func decodeYCbCr(buf []byte, width, height, h, v int) []byte {
+	out := make([]byte, width*height*3)
+	unitsAcross := (width + h - 1) / h
+	unitSize := h*v + 2
+	for uy := 0; uy*v < height; uy++ {
+		for ux := 0; ux < unitsAcross; ux++ {
+			off := (uy*unitsAcross + ux) * unitSize
+			if off+unitSize > len(buf) {
+				return out
+			}
+			for j := 0; j < v; j++ {
+				for i := 0; i < h; i++ {
+					x, y := ux*h+i, uy*v+j
+					if x < width && y < height {
+						p := (y*width + x) * 3
+						out[p], out[p+1], out[p+2] = buf[off+j*h+i], buf[off+h*v], buf[off+h*v+1]
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// decodeCIELab converts 8 bit CIELab samples to RGB in place.
+func decodeCIELab(buf []byte) {
+	for off := 0; off+3 <= len(buf); off += 3 {
+		l := float64(buf[off]) * 100 / 255
+		buf[off], buf[off+1], buf[off+2] = labToRGB(l, float64(int8(buf[off+1])), float64(int8(buf[off+2])))
+	}
+}
+
+// labToRGB converts CIE L*a*b* with a D65 white point to sRGB.
+func labToRGB(l, a, b float64) (uint8, uint8, uint8) {
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+	inv := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+	x, y, z := 0.95047*inv(fx), inv(fy), 1.08883*inv(fz)
+
+	gamma := func(c float64) uint8 {
+		if c <= 0.0031308 {
+			c *= 12.92
+		} else {
+			c = 1.055*math.Pow(c, 1/2.4) - 0.055
+		}
+		return uint8(roundClamp(c*255, 0, 255))
+	}
+	return gamma(3.2404542*x - 1.5371385*y - 0.4985314*z),
+		gamma(-0.9692660*x + 1.8760108*y + 0.0415560*z),
+		gamma(0.0556434*x - 0.2040259*y + 1.0572252*z)
+}
+
+func ycbcrFactors(ratio image.YCbCrSubsampleRatio) (int, int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2
+	}
+	return 1, 1
+}
+
+// encodeYCbCr writes r as YCbCr data units, taking the chroma of each unit
+// from its first pixel and repeating the last row and column where the
+// raster does not fill a whole unit.
+func encodeYCbCr(w io.Writer, r *Raster) error {
+	h, v := r.subsampling()
+	unit := make([]byte, h*v+2)
+	buf := make([]byte, 0, ((r.Width+h-1)/h)*len(unit))
+	for y0 := 0; y0 < r.Height; y0 += v {
+		buf = buf[:0]
+		for x0 := 0; x0 < r.Width; x0 += h {
+			for j := 0; j < v; j++ {
+				for i := 0; i < h; i++ {
+					unit[j*h+i] = r.Pix[(minInt(y0+j, r.Height-1)*r.Width+minInt(x0+i, r.Width-1))*3]
+				}
+			}
+			c := (y0*r.Width + x0) * 3
+			unit[h*v], unit[h*v+1] = r.Pix[c+1], r.Pix[c+2]
+			buf = append(buf, unit...)
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
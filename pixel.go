@@ -0,0 +1,78 @@
+package cog
+
+import (
+	"image"
+	"math"
+)
+
+type pixelBuffer struct {
+	width  int
+	height int
+	bands  int
+	data   *Raster
+	get    func(i, b int) float64
+	set    func(i, b int, v float64)
+}
+
+func clampFloat(v, min, max float64) float64 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func roundClamp(v, min, max float64) float64 {
+	return clampFloat(math.Round(v), min, max)
+}
+
+// newPixelBuffer gives per sample access to data, converting it to a Raster
+// first. Writes only reach data when it is a *Raster.
+func newPixelBuffer(data interface{}, width, height int) (*pixelBuffer, error) {
+	r, err := toRaster(data, width, height, false)
+	if err != nil {
+		return nil, err
+	}
+	return &pixelBuffer{width: r.Width, height: r.Height, bands: r.Bands * r.Type.info().parts, data: r, get: r.get, set: r.set}, nil
+}
+
+func (p *pixelBuffer) at(x, y, b int) float64 {
+	return p.get(y*p.width+x, b)
+}
+
+func (p *pixelBuffer) put(x, y, b int, v float64) {
+	p.set(y*p.width+x, b, v)
+}
+
+// makePixelData returns an empty raster of the given size with the layout
+// of like.
+func makePixelData(like *Raster, width, height int) *Raster {
+	r := NewRaster(width, height, like.Bands, like.Type)
+	r.Order, r.Color, r.Palette, r.Subsampling = like.Order, like.Color, like.Palette, like.Subsampling
+	return r
+}
+
+func cropPixelData(data *Raster, rect image.Rectangle, window image.Rectangle) (*Raster, error) {
+	src, err := newPixelBuffer(data, rect.Dx(), rect.Dy())
+	if err != nil {
+		return nil, err
+	}
+	out := makePixelData(data, window.Dx(), window.Dy())
+	dst, err := newPixelBuffer(out, window.Dx(), window.Dy())
+	if err != nil {
+		return nil, err
+	}
+	for y := window.Min.Y; y < window.Max.Y; y++ {
+		for x := window.Min.X; x < window.Max.X; x++ {
+			for b := 0; b < src.bands; b++ {
+				dst.put(x-window.Min.X, y-window.Min.Y, b, src.at(x-rect.Min.X, y-rect.Min.Y, b))
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,195 @@
+package cog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// Quantization stores physical values as samples of Type, with
+// stored = (physical - Offset) / Scale. The scale, offset and unit are
+// recorded in the band metadata so readers can recover physical values.
+type Quantization struct {
+	Type   DataType
+	Scale  float64
+	Offset float64
+	Unit   string
+}
+
+// check validates q for the nodata value of the layer. Integer types need
+// one that they can store, as NaN has no integer representation.
+func (q *Quantization) check(noData *float64) error {
+	if q.Scale == 0 || math.IsNaN(q.Scale) || math.IsInf(q.Scale, 0) {
+		return errors.New("quantization scale must be finite and non-zero")
+	}
+	if q.Type == DTUnknown || q.Type.IsComplex() {
+		return errors.New("quantization needs a real output type")
+	}
+	if q.Type.SampleFormat() == SampleFormatIEEEFP {
+		return nil
+	}
+	if noData == nil {
+		return fmt.Errorf("quantization to %s needs a nodata value", q.Type)
+	}
+	if r := q.Type.valueRange(); *noData != math.Trunc(*noData) || *noData < r[0] || *noData > r[1] {
+		return fmt.Errorf("nodata %v cannot be stored as %s", *noData, q.Type)
+	}
+	return nil
+}
+
+func (q *Quantization) options(noData *float64) *ConvertOptions {
+	return &ConvertOptions{Scale: 1 / q.Scale, Offset: -q.Offset / q.Scale, DstNoData: noData, AvoidNoData: true}
+}
+
+func (q *Quantization) apply(md *GDALMetadata, bands int) {
+	if bands < 1 {
+		bands = 1
+	}
+	for b := 0; b < bands; b++ {
+		scale, offset := q.Scale, q.Offset
+		band := md.Band(b)
+		band.Scale, band.Offset = &scale, &offset
+		if q.Unit != "" {
+			band.Unit = q.Unit
+		}
+	}
+}
+
+// quantizedSource converts the physical values of src on first use. NaN
+// samples become the nodata value of the layer, which valid samples are
+// kept off.
+type quantizedSource struct {
+	src       TileSource
+	q         Quantization
+	noData    *float64
+	ctype     CompressionType
+	predictor Predictor
+	enc       binary.ByteOrder
+	conv      *RawSource
+	err       error
+}
+
+func newQuantizedSource(src TileSource, q Quantization, noData *float64) *quantizedSource {
+	return &quantizedSource{src: src, q: q, noData: noData, ctype: src.CompressionType()}
+}
+
+func (s *quantizedSource) convert() error {
+	if s.conv == nil && s.err == nil {
+		s.conv, s.err = ConvertSource(s.src, s.q.Type, s.q.options(s.noData))
+		if s.err == nil {
+			s.conv.SetCompressionType(s.ctype)
+			s.conv.SetPredictor(s.predictor)
+			if s.enc != nil {
+				s.conv.SetByteOrder(s.enc)
+			}
+		}
+	}
+	return s.err
+}
+
+func (s *quantizedSource) Bounds() image.Rectangle {
+	return s.src.Bounds()
+}
+
+func (s *quantizedSource) Encode(w io.Writer, ifd *IFD) (uint32, *IFD, error) {
+	if err := s.convert(); err != nil {
+		return 0, nil, err
+	}
+	return s.conv.Encode(w, ifd)
+}
+
+func (s *quantizedSource) Reset() {
+	s.src.Reset()
+	s.conv = nil
+}
+
+func (s *quantizedSource) Data() *Raster {
+	if s.convert() != nil {
+		return nil
+	}
+	return s.conv.Data()
+}
+
+func (s *quantizedSource) CompressionType() CompressionType {
+	return s.ctype
+}
+
+func (s *quantizedSource) SetCompressionType(ctype CompressionType) {
+	s.ctype = ctype
+}
+
+func (s *quantizedSource) SetPredictor(p Predictor) {
+	s.predictor = p
+}
+
+func (s *quantizedSource) SetByteOrder(enc binary.ByteOrder) {
+	s.enc = enc
+}
+
+// GetScaleOffset returns the scale and offset of each band of image i,
+// falling back to the metadata of the full resolution image for overviews.
+func (m Reader) GetScaleOffset(i int) ([]float64, []float64, error) {
+	ifd := m.ifds[i]
+	if ifd.GDALMetaData == "" {
+		ifd = m.ifds[0]
+	}
+	md, err := ifd.Metadata()
+	if err != nil {
+		return nil, nil, err
+	}
+	n := int(m.ifds[i].SamplesPerPixel)
+	if n < 1 {
+		n = 1
+	}
+	scales, offsets := make([]float64, n), make([]float64, n)
+	for b := 0; b < n; b++ {
+		scales[b] = 1
+		if b < len(md.Bands) {
+			if s := md.Bands[b].Scale; s != nil {
+				scales[b] = *s
+			}
+			if o := md.Bands[b].Offset; o != nil {
+				offsets[b] = *o
+			}
+		}
+	}
+	return scales, offsets, nil
+}
+
+// GetUnscaled returns the physical values of image i as a Float64 raster,
+// applying the per band scale and offset. Nodata samples become NaN.
+func (m Reader) GetUnscaled(i int) (*Raster, error) {
+	if i >= len(m.Data) || m.Data[i] == nil {
+		return nil, errors.New("image data not loaded")
+	}
+	size := m.GetSize(i)
+	w, h := int(size[0]), int(size[1])
+	src, err := newPixelBuffer(m.Data[i], w, h)
+	if err != nil {
+		return nil, err
+	}
+	scales, offsets, err := m.GetScaleOffset(i)
+	if err != nil {
+		return nil, err
+	}
+	noData := m.GetNoData(i)
+	dst := NewRaster(w, h, src.bands, DTFloat64)
+	for b := 0; b < src.bands; b++ {
+		scale, offset := 1.0, 0.0
+		if b < len(scales) {
+			scale, offset = scales[b], offsets[b]
+		}
+		for k := 0; k < w*h; k++ {
+			v := src.get(k, b)
+			if math.IsNaN(v) || isNoData(noData, v) {
+				dst.set(k, b, math.NaN())
+			} else {
+				dst.set(k, b, v*scale+offset)
+			}
+		}
+	}
+	return dst, nil
+}
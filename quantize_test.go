@@ -0,0 +1,72 @@
+package cog
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/flywave/go-geo"
+	vec2d "github.com/flywave/go3d/float64/vec2"
+)
+
+func TestQuantize(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	data := make([]float64, 16*16)
+	for i := range data {
+		data[i] = -100 + float64(i)*0.5
+	}
+	data[7] = math.NaN()
+	data[8] = -200 // quantises onto the nodata value
+
+	noData := 0.0
+	opts := &Options{
+		NoData:   &noData,
+		Quantize: &Quantization{Type: DTUInt16, Scale: 0.1, Offset: -200, Unit: "m"},
+	}
+	buf := &bytes.Buffer{}
+	box := vec2d.Rect{Min: vec2d.T{116, 39}, Max: vec2d.T{117, 40}}
+	if err := WriteTileTo(buf, NewSource(data, &rect, CTDeflate), box, geo.NewProj(4326), [2]uint32{16, 16}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	r := ReadFrom(bytes.NewReader(buf.Bytes()))
+	if r.Data[0].Type != DTUInt16 {
+		t.Fatalf("stored as %s", r.Data[0].Type)
+	}
+	md, err := r.GetMetadata(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := md.Band(0); b.Scale == nil || *b.Scale != 0.1 || *b.Offset != -200 || b.Unit != "m" {
+		t.Fatalf("band metadata %+v", b)
+	}
+	phys, err := r.GetUnscaled(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(phys.At(7, 0, 0)) {
+		t.Fatalf("nodata read as %v", phys.At(7, 0, 0))
+	}
+	if v := phys.At(8, 0, 0); math.IsNaN(v) || math.Abs(v+200) > 0.1+1e-9 {
+		t.Fatalf("value at nodata read as %v", v)
+	}
+	for i, v := range data {
+		if i != 7 && i != 8 && math.Abs(phys.At(i%16, i/16, 0)-v) > 0.05 {
+			t.Fatalf("pixel %d: got %v, want %v", i, phys.At(i%16, i/16, 0), v)
+		}
+	}
+}
+
+func TestQuantizeNeedsNoData(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	box := vec2d.Rect{Min: vec2d.T{116, 39}, Max: vec2d.T{117, 40}}
+	bad := -1.0
+	for _, nd := range []*float64{nil, &bad} {
+		opts := &Options{NoData: nd, Quantize: &Quantization{Type: DTUInt16, Scale: 0.1}}
+		src := NewSource(make([]float64, 16*16), &rect, CTNone)
+		if err := WriteTileTo(&bytes.Buffer{}, src, box, geo.NewProj(4326), [2]uint32{16, 16}, opts); err == nil {
+			t.Fatalf("quantized to UInt16 with nodata %v", nd)
+		}
+	}
+}
@@ -0,0 +1,587 @@
+package cog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+)
+
+// DataType identifies the sample type of raster data, using the GDAL names.
+type DataType int
+
+const (
+	DTUnknown DataType = iota
+	DTByte
+	DTInt8
+	DTUInt16
+	DTInt16
+	DTUInt32
+	DTInt32
+	DTUInt64
+	DTInt64
+	DTFloat32
+	DTFloat64
+	DTCInt16
+	DTCInt32
+	DTCFloat32
+	DTCFloat64
+)
+
+type sampleAccessors func(data interface{}) (get func(i, b int) float64, set func(i, b int, v float64))
+
+type dataTypeInfo struct {
+	name   string
+	bits   int
+	format uint16
+	parts  int         // 2 for complex samples
+	zero   interface{} // empty slice of the Go sample type
+	access sampleAccessors
+}
+
+// dataTypes describes every supported sample type; a new type only needs
+// an entry here to be read, written and processed.
+var dataTypes = [...]dataTypeInfo{
+	DTUnknown: {name: "Unknown"},
+	DTByte: {"Byte", 8, SampleFormatUInt, 1, []uint8(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]uint8)
+		return func(i, b int) float64 { return float64(d[i]) },
+			func(i, b int, v float64) { d[i] = uint8(roundClamp(v, 0, math.MaxUint8)) }
+	}},
+	DTInt8: {"Int8", 8, SampleFormatInt, 1, []int8(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]int8)
+		return func(i, b int) float64 { return float64(d[i]) },
+			func(i, b int, v float64) { d[i] = int8(roundClamp(v, math.MinInt8, math.MaxInt8)) }
+	}},
+	DTUInt16: {"UInt16", 16, SampleFormatUInt, 1, []uint16(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]uint16)
+		return func(i, b int) float64 { return float64(d[i]) },
+			func(i, b int, v float64) { d[i] = uint16(roundClamp(v, 0, math.MaxUint16)) }
+	}},
+	DTInt16: {"Int16", 16, SampleFormatInt, 1, []int16(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]int16)
+		return func(i, b int) float64 { return float64(d[i]) },
+			func(i, b int, v float64) { d[i] = int16(roundClamp(v, math.MinInt16, math.MaxInt16)) }
+	}},
+	DTUInt32: {"UInt32", 32, SampleFormatUInt, 1, []uint32(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]uint32)
+		return func(i, b int) float64 { return float64(d[i]) },
+			func(i, b int, v float64) { d[i] = uint32(roundClamp(v, 0, math.MaxUint32)) }
+	}},
+	DTInt32: {"Int32", 32, SampleFormatInt, 1, []int32(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]int32)
+		return func(i, b int) float64 { return float64(d[i]) },
+			func(i, b int, v float64) { d[i] = int32(roundClamp(v, math.MinInt32, math.MaxInt32)) }
+	}},
+	DTUInt64: {"UInt64", 64, SampleFormatUInt, 1, []uint64(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]uint64)
+		return func(i, b int) float64 { return float64(d[i]) },
+			func(i, b int, v float64) { d[i] = uint64(roundClamp(v, 0, math.MaxUint64)) }
+	}},
+	DTInt64: {"Int64", 64, SampleFormatInt, 1, []int64(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]int64)
+		return func(i, b int) float64 { return float64(d[i]) },
+			func(i, b int, v float64) { d[i] = int64(roundClamp(v, math.MinInt64, math.MaxInt64)) }
+	}},
+	DTFloat32: {"Float32", 32, SampleFormatIEEEFP, 1, []float32(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]float32)
+		return func(i, b int) float64 { return float64(d[i]) },
+			func(i, b int, v float64) { d[i] = float32(v) }
+	}},
+	DTFloat64: {"Float64", 64, SampleFormatIEEEFP, 1, []float64(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]float64)
+		return func(i, b int) float64 { return d[i] },
+			func(i, b int, v float64) { d[i] = v }
+	}},
+	DTCInt16: {"CInt16", 32, SampleFormatComplexInt, 2, []ComplexInt16(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]ComplexInt16)
+		return func(i, b int) float64 {
+				if b == 0 {
+					return float64(d[i].Real)
+				}
+				return float64(d[i].Imag)
+			}, func(i, b int, v float64) {
+				if b == 0 {
+					d[i].Real = int16(roundClamp(v, math.MinInt16, math.MaxInt16))
+				} else {
+					d[i].Imag = int16(roundClamp(v, math.MinInt16, math.MaxInt16))
+				}
+			}
+	}},
+	DTCInt32: {"CInt32", 64, SampleFormatComplexInt, 2, []ComplexInt32(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]ComplexInt32)
+		return func(i, b int) float64 {
+				if b == 0 {
+					return float64(d[i].Real)
+				}
+				return float64(d[i].Imag)
+			}, func(i, b int, v float64) {
+				if b == 0 {
+					d[i].Real = int32(roundClamp(v, math.MinInt32, math.MaxInt32))
+				} else {
+					d[i].Imag = int32(roundClamp(v, math.MinInt32, math.MaxInt32))
+				}
+			}
+	}},
+	DTCFloat32: {"CFloat32", 64, SampleFormatComplexIEEEFP, 2, []complex64(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]complex64)
+		return func(i, b int) float64 {
+				if b == 0 {
+					return float64(real(d[i]))
+				}
+				return float64(imag(d[i]))
+			}, func(i, b int, v float64) {
+				if b == 0 {
+					d[i] = complex(float32(v), imag(d[i]))
+				} else {
+					d[i] = complex(real(d[i]), float32(v))
+				}
+			}
+	}},
+	DTCFloat64: {"CFloat64", 128, SampleFormatComplexIEEEFP, 2, []complex128(nil), func(data interface{}) (func(i, b int) float64, func(i, b int, v float64)) {
+		d := data.([]complex128)
+		return func(i, b int) float64 {
+				if b == 0 {
+					return real(d[i])
+				}
+				return imag(d[i])
+			}, func(i, b int, v float64) {
+				if b == 0 {
+					d[i] = complex(v, imag(d[i]))
+				} else {
+					d[i] = complex(real(d[i]), v)
+				}
+			}
+	}},
+}
+
+func (t DataType) info() dataTypeInfo {
+	if t < 0 || int(t) >= len(dataTypes) {
+		return dataTypes[DTUnknown]
+	}
+	return dataTypes[t]
+}
+
+func (t DataType) String() string {
+	return t.info().name
+}
+
+// Size returns the number of bytes of one sample.
+func (t DataType) Size() int {
+	return t.info().bits / 8
+}
+
+func (t DataType) BitsPerSample() uint16 {
+	return uint16(t.info().bits)
+}
+
+func (t DataType) SampleFormat() uint16 {
+	return t.info().format
+}
+
+func (t DataType) IsComplex() bool {
+	return t.info().parts == 2
+}
+
+// DataTypeOf returns the type of samples with the given BitsPerSample and
+// SampleFormat. Packed samples narrower than a byte are read as DTByte.
+func DataTypeOf(bits, format uint16) DataType {
+	if format == 0 {
+		format = SampleFormatUInt
+	}
+	if bits < 8 && format == SampleFormatUInt {
+		bits = 8
+	}
+	for t, info := range dataTypes {
+		if info.bits == int(bits) && info.format == format {
+			return DataType(t)
+		}
+	}
+	return DTUnknown
+}
+
+// DataTypeOfSlice returns the type of the samples in a typed slice such as
+// []float32, or DTUnknown for anything else.
+func DataTypeOfSlice(data interface{}) DataType {
+	rt := reflect.TypeOf(data)
+	for t, info := range dataTypes {
+		if info.zero != nil && reflect.TypeOf(info.zero) == rt {
+			return DataType(t)
+		}
+	}
+	return DTUnknown
+}
+
+// MakeSlice returns a typed slice of n samples, e.g. []float32 for DTFloat32.
+func (t DataType) MakeSlice(n int) interface{} {
+	zero := t.info().zero
+	if zero == nil {
+		return nil
+	}
+	return reflect.MakeSlice(reflect.TypeOf(zero), n, n).Interface()
+}
+
+func decodeSamples(dst interface{}, i int, buf []byte, order binary.ByteOrder) error {
+	v := reflect.ValueOf(dst)
+	n := len(buf) / DataTypeOfSlice(dst).Size()
+	if i+n > v.Len() {
+		return errors.New("sample buffer too small")
+	}
+	return binary.Read(bytes.NewReader(buf), order, v.Slice(i, i+n).Interface())
+}
+
+// ColorInterp tells how the bands of a Raster map to colours.
+type ColorInterp int
+
+const (
+	// ColorDefault is gray for one band and RGB for three or four bands,
+	// the fourth being unassociated alpha.
+	ColorDefault ColorInterp = iota
+	// ColorPremultiplied is RGB with associated alpha.
+	ColorPremultiplied
+	// ColorPaletted indexes Palette with a single Byte band.
+	ColorPaletted
+	ColorCMYK
+	// ColorYCbCr holds Y, Cb and Cr at full resolution; the chroma is
+	// subsampled by Subsampling when encoded.
+	ColorYCbCr
+)
+
+// Raster is a pixel interleaved buffer of Bands samples per pixel. The
+// samples are stored in Pix using Order.
+type Raster struct {
+	Width  int
+	Height int
+	Bands  int
+	Type   DataType
+	Order  binary.ByteOrder
+	Pix    []byte
+
+	Color       ColorInterp
+	Palette     color.Palette
+	Subsampling [2]int // horizontal and vertical chroma subsampling, 0 is 1
+}
+
+func NewRaster(width, height, bands int, typ DataType) *Raster {
+	return &Raster{Width: width, Height: height, Bands: bands, Type: typ, Order: tiffByteOrder, Pix: make([]byte, width*height*bands*typ.Size())}
+}
+
+// RasterFrom copies typed slices, images and *Raster into a new Raster.
+// Images take their size from their bounds.
+func RasterFrom(data interface{}, width, height int) (*Raster, error) {
+	return toRaster(data, width, height, true)
+}
+
+// toRaster maps typed slices and images to a Raster. It shares the pixels
+// of a *Raster, and of images whose layout matches, unless copyPix is set.
+func toRaster(data interface{}, width, height int, copyPix bool) (*Raster, error) {
+	if r, ok := data.(*Raster); ok {
+		if r == nil {
+			return nil, errors.New("no raster data")
+		}
+		if !copyPix {
+			return r, nil
+		}
+		c := *r
+		c.Pix = append([]byte(nil), r.Pix...)
+		return &c, nil
+	}
+	if t := DataTypeOfSlice(data); t != DTUnknown {
+		if reflect.ValueOf(data).Len() != width*height {
+			return nil, fmt.Errorf("%s data does not hold %dx%d samples", t, width, height)
+		}
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, tiffByteOrder, data); err != nil {
+			return nil, err
+		}
+		return &Raster{Width: width, Height: height, Bands: 1, Type: t, Order: tiffByteOrder, Pix: buf.Bytes()}, nil
+	}
+	img, ok := data.(image.Image)
+	if !ok {
+		return nil, errors.New("unsupported pixel data type")
+	}
+	b := img.Bounds()
+	width, height = b.Dx(), b.Dy()
+	var r *Raster
+	switch m := img.(type) {
+	case *image.Gray:
+		r = rasterFromPix(m.Pix, m.Stride, width, height, 1, DTByte, copyPix)
+	case *image.Gray16:
+		r = rasterFromPix(m.Pix, m.Stride, width, height, 1, DTUInt16, copyPix)
+	case *image.Paletted:
+		r = rasterFromPix(m.Pix, m.Stride, width, height, 1, DTByte, copyPix)
+		r.Color, r.Palette = ColorPaletted, m.Palette
+	case *image.RGBA:
+		r = rasterFromPix(m.Pix, m.Stride, width, height, 4, DTByte, copyPix)
+		r.Color = ColorPremultiplied
+	case *image.RGBA64:
+		r = rasterFromPix(m.Pix, m.Stride, width, height, 4, DTUInt16, copyPix)
+		r.Color = ColorPremultiplied
+	case *image.NRGBA:
+		r = rasterFromPix(m.Pix, m.Stride, width, height, 4, DTByte, copyPix)
+	case *image.NRGBA64:
+		r = rasterFromPix(m.Pix, m.Stride, width, height, 4, DTUInt16, copyPix)
+	case *image.CMYK:
+		r = rasterFromPix(m.Pix, m.Stride, width, height, 4, DTByte, copyPix)
+		r.Color = ColorCMYK
+	case *image.YCbCr:
+		r = NewRaster(width, height, 3, DTByte)
+		h, v := ycbcrFactors(m.SubsampleRatio)
+		r.Color, r.Subsampling = ColorYCbCr, [2]int{h, v}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				i, c := (y*width+x)*3, m.COffset(b.Min.X+x, b.Min.Y+y)
+				r.Pix[i], r.Pix[i+1], r.Pix[i+2] = m.Y[m.YOffset(b.Min.X+x, b.Min.Y+y)], m.Cb[c], m.Cr[c]
+			}
+		}
+	default:
+		r = NewRaster(width, height, 4, DTByte)
+		r.Color = ColorPremultiplied
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				cr, cg, cb, ca := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				i := (y*width + x) * 4
+				r.Pix[i], r.Pix[i+1], r.Pix[i+2], r.Pix[i+3] = uint8(cr>>8), uint8(cg>>8), uint8(cb>>8), uint8(ca>>8)
+			}
+		}
+	}
+	return r, nil
+}
+
+func rasterFromPix(pix []byte, stride, width, height, bands int, typ DataType, copyPix bool) *Raster {
+	r := &Raster{Width: width, Height: height, Bands: bands, Type: typ, Order: binary.BigEndian}
+	row := width * bands * typ.Size()
+	if !copyPix && stride == row && len(pix) >= row*height {
+		r.Pix = pix[:row*height]
+		return r
+	}
+	r.Pix = make([]byte, row*height)
+	for y := 0; y < height; y++ {
+		copy(r.Pix[y*row:(y+1)*row], pix[y*stride:])
+	}
+	return r
+}
+
+func (r *Raster) subsampling() (int, int) {
+	h, v := r.Subsampling[0], r.Subsampling[1]
+	if h < 1 {
+		h = 1
+	}
+	if v < 1 {
+		v = 1
+	}
+	return h, v
+}
+
+func (r *Raster) Bounds() image.Rectangle {
+	return image.Rect(0, 0, r.Width, r.Height)
+}
+
+func (r *Raster) offset(i, b int) int {
+	return (i*r.Bands + b) * r.Type.Size()
+}
+
+// At returns sample b of the pixel at x, y. Complex samples return their
+// real part.
+func (r *Raster) At(x, y, b int) float64 {
+	return r.get(y*r.Width+x, b*r.Type.info().parts)
+}
+
+func (r *Raster) Set(x, y, b int, v float64) {
+	r.set(y*r.Width+x, b*r.Type.info().parts, v)
+}
+
+func (r *Raster) Complex(x, y, b int) complex128 {
+	off := r.offset(y*r.Width+x, b)
+	part := r.Type.Size() / 2
+	return complex(getSample(r.Pix[off:], r.Order, r.Type.SampleFormat(), part), getSample(r.Pix[off+part:], r.Order, r.Type.SampleFormat(), part))
+}
+
+func (r *Raster) SetComplex(x, y, b int, v complex128) {
+	off := r.offset(y*r.Width+x, b)
+	part := r.Type.Size() / 2
+	putSample(r.Pix[off:], r.Order, r.Type.SampleFormat(), part, real(v))
+	putSample(r.Pix[off+part:], r.Order, r.Type.SampleFormat(), part, imag(v))
+}
+
+// get and set address the real and imaginary parts of complex samples as
+// separate bands, like pixelBuffer does for complex slices.
+func (r *Raster) get(i, b int) float64 {
+	parts := r.Type.info().parts
+	size := r.Type.Size() / parts
+	off := r.offset(i, b/parts) + b%parts*size
+	return getSample(r.Pix[off:], r.Order, r.Type.SampleFormat(), size)
+}
+
+func (r *Raster) set(i, b int, v float64) {
+	parts := r.Type.info().parts
+	size := r.Type.Size() / parts
+	off := r.offset(i, b/parts) + b%parts*size
+	putSample(r.Pix[off:], r.Order, r.Type.SampleFormat(), size, v)
+}
+
+func getSample(p []byte, order binary.ByteOrder, format uint16, size int) float64 {
+	var u uint64
+	switch size {
+	case 1:
+		u = uint64(p[0])
+	case 2:
+		u = uint64(order.Uint16(p))
+	case 4:
+		u = uint64(order.Uint32(p))
+	case 8:
+		u = order.Uint64(p)
+	}
+	switch format {
+	case SampleFormatIEEEFP, SampleFormatComplexIEEEFP:
+		if size == 4 {
+			return float64(math.Float32frombits(uint32(u)))
+		}
+		return math.Float64frombits(u)
+	case SampleFormatInt, SampleFormatComplexInt:
+		shift := uint(64 - size*8)
+		return float64(int64(u<<shift) >> shift)
+	}
+	return float64(u)
+}
+
+func putSample(p []byte, order binary.ByteOrder, format uint16, size int, v float64) {
+	var u uint64
+	switch format {
+	case SampleFormatIEEEFP, SampleFormatComplexIEEEFP:
+		if size == 4 {
+			u = uint64(math.Float32bits(float32(v)))
+		} else {
+			u = math.Float64bits(v)
+		}
+	case SampleFormatInt, SampleFormatComplexInt:
+		max := math.Ldexp(1, size*8-1)
+		u = uint64(int64(roundClamp(v, -max, max-1)))
+	default:
+		u = uint64(roundClamp(v, 0, math.Ldexp(1, size*8)-1))
+	}
+	switch size {
+	case 1:
+		p[0] = byte(u)
+	case 2:
+		order.PutUint16(p, uint16(u))
+	case 4:
+		order.PutUint32(p, uint32(u))
+	case 8:
+		order.PutUint64(p, u)
+	}
+}
+
+// Bytes returns the samples encoded with order.
+func (r *Raster) Bytes(order binary.ByteOrder) []byte {
+	size := r.Type.Size() / r.Type.info().parts
+	if order == r.Order || size == 1 {
+		return r.Pix
+	}
+	out := make([]byte, len(r.Pix))
+	for i := 0; i+size <= len(r.Pix); i += size {
+		for j := 0; j < size; j++ {
+			out[i+j] = r.Pix[i+size-1-j]
+		}
+	}
+	return out
+}
+
+// Data returns a single band raster as a typed slice such as []float32.
+func (r *Raster) Data() (interface{}, error) {
+	if r.Bands != 1 {
+		return nil, errors.New("only single band rasters convert to a slice")
+	}
+	data := r.Type.MakeSlice(r.Width * r.Height)
+	if data == nil {
+		return nil, errors.New("unknown raster data type")
+	}
+	if err := decodeSamples(data, 0, r.Pix, r.Order); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Image converts r to the image type of its colour interpretation:
+// paletted, CMYK and YCbCr rasters, or 8 and 16 bit rasters with 1 (gray),
+// 3 (RGB) or 4 (RGBA) bands.
+func (r *Raster) Image() (image.Image, error) {
+	rect := r.Bounds()
+	n := r.Width * r.Height
+	switch {
+	case r.Color == ColorPaletted && r.Type == DTByte && r.Bands == 1:
+		m := image.NewPaletted(rect, r.Palette)
+		copy(m.Pix, r.Pix)
+		return m, nil
+	case r.Color == ColorCMYK && r.Type == DTByte && r.Bands == 4:
+		m := image.NewCMYK(rect)
+		copy(m.Pix, r.Pix)
+		return m, nil
+	case r.Color == ColorYCbCr && r.Type == DTByte && r.Bands == 3:
+		h, v := r.subsampling()
+		ratio, err := ycbcrRatio(h, v)
+		if err != nil {
+			return nil, err
+		}
+		m := image.NewYCbCr(rect, ratio)
+		for y := 0; y < r.Height; y++ {
+			for x := 0; x < r.Width; x++ {
+				i := (y*r.Width + x) * 3
+				m.Y[m.YOffset(x, y)] = r.Pix[i]
+				if x%h == 0 && y%v == 0 {
+					c := m.COffset(x, y)
+					m.Cb[c], m.Cr[c] = r.Pix[i+1], r.Pix[i+2]
+				}
+			}
+		}
+		return m, nil
+	case r.Type == DTByte && r.Bands == 1:
+		m := image.NewGray(rect)
+		copy(m.Pix, r.Pix)
+		return m, nil
+	case r.Type == DTUInt16 && r.Bands == 1:
+		m := image.NewGray16(rect)
+		copy(m.Pix, r.Bytes(binary.BigEndian))
+		return m, nil
+	case r.Type == DTByte && (r.Bands == 3 || r.Bands == 4):
+		var pix []uint8
+		var m image.Image
+		if r.Color == ColorPremultiplied {
+			rgba := image.NewRGBA(rect)
+			pix, m = rgba.Pix, rgba
+		} else {
+			nrgba := image.NewNRGBA(rect)
+			pix, m = nrgba.Pix, nrgba
+		}
+		for i := 0; i < n; i++ {
+			copy(pix[i*4:i*4+r.Bands], r.Pix[i*r.Bands:])
+			if r.Bands == 3 {
+				pix[i*4+3] = 0xff
+			}
+		}
+		return m, nil
+	case r.Type == DTUInt16 && (r.Bands == 3 || r.Bands == 4):
+		var pix []uint8
+		var m image.Image
+		if r.Color == ColorPremultiplied {
+			rgba := image.NewRGBA64(rect)
+			pix, m = rgba.Pix, rgba
+		} else {
+			nrgba := image.NewNRGBA64(rect)
+			pix, m = nrgba.Pix, nrgba
+		}
+		src := r.Bytes(binary.BigEndian)
+		for i := 0; i < n; i++ {
+			copy(pix[i*8:i*8+r.Bands*2], src[i*r.Bands*2:])
+			if r.Bands == 3 {
+				pix[i*8+6], pix[i*8+7] = 0xff, 0xff
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%d band %s raster has no image representation", r.Bands, r.Type)
+}
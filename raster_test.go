@@ -0,0 +1,107 @@
+package cog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/flywave/go-geo"
+
+	vec2d "github.com/flywave/go3d/float64/vec2"
+)
+
+func TestRasterDataTypes(t *testing.T) {
+	for dt := DTByte; dt <= DTCFloat64; dt++ {
+		if got := DataTypeOf(dt.BitsPerSample(), dt.SampleFormat()); got != dt {
+			t.Fatalf("%s: DataTypeOf returned %s", dt, got)
+		}
+		src := NewRaster(3, 2, 1, dt)
+		p, err := newPixelBuffer(src, 3, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 6; i++ {
+			for b := 0; b < p.bands; b++ {
+				p.set(i, b, float64(i*10-b*3))
+			}
+		}
+		data, err := src.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if DataTypeOfSlice(data) != dt {
+			t.Fatalf("%s: slice type %T", dt, data)
+		}
+
+		r, err := RasterFrom(data, 3, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := r.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(back, data) {
+			t.Fatalf("%s: slice round trip differs", dt)
+		}
+
+		be := *r
+		be.Pix, be.Order = r.Bytes(binary.BigEndian), binary.BigEndian
+		for i := 0; i < 6; i++ {
+			if r.At(i%3, i/3, 0) != p.get(i, 0) || be.At(i%3, i/3, 0) != p.get(i, 0) {
+				t.Fatalf("%s: sample %d is %v, want %v", dt, i, be.At(i%3, i/3, 0), p.get(i, 0))
+			}
+		}
+	}
+	if DataTypeOf(4, 1) != DTByte || DataTypeOf(24, 1) != DTUnknown {
+		t.FailNow()
+	}
+}
+
+func TestRasterSource(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	box := vec2d.Rect{Min: vec2d.T{116, 39}, Max: vec2d.T{117, 40}}
+	rgb := NewRaster(16, 16, 3, DTByte)
+	elev := NewRaster(16, 16, 1, DTFloat32)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			for b := 0; b < 3; b++ {
+				rgb.Set(x, y, b, float64(x*16+y+b*50))
+			}
+			elev.Set(x, y, 0, float64(x)-float64(y)/4)
+		}
+	}
+
+	for _, src := range []*Raster{rgb, elev} {
+		buf := &bytes.Buffer{}
+		if err := WriteTileTo(buf, NewSource(src, &rect, CTLZW), box, geo.NewProj(4326), [2]uint32{16, 16}, nil); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadFrom(bytes.NewReader(buf.Bytes())).GetRaster(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				for b := 0; b < src.Bands; b++ {
+					if got.At(x, y, b) != src.At(x, y, b) {
+						t.Fatalf("%d band %s: pixel %d,%d band %d is %v, want %v", src.Bands, src.Type, x, y, b, got.At(x, y, b), src.At(x, y, b))
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := elev.Image(); err == nil {
+		t.Fatal("float raster converted to an image")
+	}
+	img, err := rgb.Image()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(3, 5).RGBA(); r>>8 != 53 || g>>8 != 103 || b>>8 != 153 {
+		t.Fatalf("image pixel %v", img.At(3, 5))
+	}
+}
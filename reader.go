@@ -5,12 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	"image/color"
 	"io"
 	"io/ioutil"
-	"math"
 	"os"
-	"strconv"
 
 	vec2d "github.com/flywave/go3d/float64/vec2"
 
@@ -20,7 +17,7 @@ import (
 )
 
 type Reader struct {
-	Data  []interface{} // []uint16 |  []uint32 | []uint64 | []int16 |  []int32 | []int64 | []float32 | []float64 | image.Image
+	Data  []*Raster
 	Rects []image.Rectangle
 	ifds  []*IFD
 }
@@ -31,7 +28,13 @@ func Read(fileName string) *Reader {
 		panic(err)
 	}
 	defer f.Close()
-	tif, err := tiff.Parse(f, nil, nil)
+	return ReadTiff(f)
+}
+
+// ReadTiff reads every image of r, including stripped ones. ReadFrom only
+// accepts tiled files.
+func ReadTiff(r tiff.ReadAtReadSeeker) *Reader {
+	tif, err := tiff.Parse(r, nil, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -99,14 +102,7 @@ func (m Reader) GetPixelSize(i int) [2]float64 {
 }
 
 func (m Reader) GetNoData(i int) *float64 {
-	if s := m.ifds[i].NoData; s != "" {
-		f, err := strconv.ParseFloat(s, 64)
-		if err != nil {
-			return nil
-		}
-		return &f
-	}
-	return nil
+	return m.ifds[i].GetNoData()
 }
 
 func (m Reader) GetEPSGCode(i int) (int, error) {
@@ -137,9 +133,19 @@ func (m *Reader) parseGeoKeys(idx int) (map[uint16]interface{}, error) {
 		} else {
 			if tagLoc == TagGeoDoubleParamsTag {
 				gkDoubleParams := m.ifds[idx].GeoDoubleParamsTag
-				ret[tagNum] = gkDoubleParams[valOffset*8]
+				if int(valOffset)+int(newGeoKeyCount) > len(gkDoubleParams) {
+					return nil, errors.New("geokey double params out of range")
+				}
+				if newGeoKeyCount == 1 {
+					ret[tagNum] = gkDoubleParams[valOffset]
+				} else {
+					ret[tagNum] = gkDoubleParams[valOffset : valOffset+uint16(newGeoKeyCount)]
+				}
 			} else if tagLoc == TagGeoAsciiParamsTag {
 				gkAsciiParams := m.ifds[idx].GeoAsciiParamsTag
+				if int(valOffset)+int(newGeoKeyCount) > len(gkAsciiParams) {
+					return nil, errors.New("geokey ascii params out of range")
+				}
 				raw := gkAsciiParams[valOffset : valOffset+uint16(newGeoKeyCount)]
 				ret[tagNum] = raw
 			}
@@ -148,6 +154,18 @@ func (m *Reader) parseGeoKeys(idx int) (map[uint16]interface{}, error) {
 	return ret, nil
 }
 
+func (m Reader) GetGeoKeys(i int) (map[uint16]interface{}, error) {
+	return m.parseGeoKeys(i)
+}
+
+func (m Reader) GetMetadata(i int) (*GDALMetadata, error) {
+	return m.ifds[i].Metadata()
+}
+
+func (m Reader) GetTags(i int) []Tag {
+	return m.ifds[i].Tags()
+}
+
 func (m Reader) GetGeoTransform(i int) GeoTransform {
 	tran, err := m.ifds[i].Geotransform()
 	if err != nil {
@@ -161,40 +179,40 @@ func (m Reader) GetBounds(i int) vec2d.Rect {
 	if err != nil {
 		return vec2d.Rect{}
 	}
+	return tran.Bounds(int(m.ifds[i].ImageWidth), int(m.ifds[i].ImageLength))
+}
 
-	var miny, maxy, minx, maxx float64
-	if tran[5] < 0 {
-		maxy = tran[3]
-		miny = tran[3] + tran[5]*float64(m.ifds[i].ImageLength)
-	} else {
-		miny = tran[3]
-		maxy = tran[3] + tran[5]*float64(m.ifds[i].ImageLength)
-	}
-	if tran[1] < 0 {
-		maxx = tran[0]
-		minx = tran[0] + tran[1]*float64(m.ifds[i].ImageWidth)
-	} else {
-		minx = tran[0]
-		maxx = tran[0] + tran[1]*float64(m.ifds[i].ImageWidth)
+// GetRaster returns the pixels of image i as a Raster.
+func (m Reader) GetRaster(i int) (*Raster, error) {
+	if i >= len(m.Data) || m.Data[i] == nil {
+		return nil, errors.New("image data not loaded")
 	}
-
-	return vec2d.Rect{Min: vec2d.T{minx, miny}, Max: vec2d.T{maxx, maxy}}
+	return m.Data[i], nil
 }
 
-func (m Reader) readData(index int) (data interface{}, rect image.Rectangle, err error) {
-	compressionType := m.ifds[index].Compression
-	SampleFormat := uint16(0)
-	if len(m.ifds[index].SampleFormat) > 0 {
-		SampleFormat = m.ifds[index].SampleFormat[0]
-	}
+func (m Reader) readData(index int) (data *Raster, rect image.Rectangle, err error) {
+	ifd := m.ifds[index]
+	compressionType := ifd.Compression
 
-	width := int(m.ifds[index].ImageWidth)
-	height := int(m.ifds[index].ImageLength)
+	width := int(ifd.ImageWidth)
+	height := int(ifd.ImageLength)
 
 	rect = image.Rect(0, 0, width, height)
 
-	var off int
-	bitsPerSample := m.ifds[index].BitsPerSample
+	data, err = ifd.newRaster()
+	if err != nil {
+		return nil, rect, err
+	}
+	order := ifd.r.ByteOrder()
+	data.Order = order
+
+	bitsPerSample := int(data.Type.BitsPerSample())
+	if len(ifd.BitsPerSample) > 0 {
+		bitsPerSample = int(ifd.BitsPerSample[0])
+	}
+	spp := data.Bands
+	pixelSize := spp * data.Type.Size()
+
 	blockPadding := false
 	blockWidth := int(width)
 	blockHeight := int(height)
@@ -203,9 +221,9 @@ func (m Reader) readData(index int) (data interface{}, rect image.Rectangle, err
 
 	var blockOffsets, blockCounts []uint32
 
-	if m.ifds[index].TileWidth != 0 {
-		tileWidth := int(m.ifds[index].TileWidth)
-		tileHeight := int(m.ifds[index].TileLength)
+	if ifd.TileWidth != 0 {
+		tileWidth := int(ifd.TileWidth)
+		tileHeight := int(ifd.TileLength)
 
 		blockPadding = true
 
@@ -215,29 +233,29 @@ func (m Reader) readData(index int) (data interface{}, rect image.Rectangle, err
 		blocksAcross = (width + blockWidth - 1) / blockWidth
 		blocksDown = (height + blockHeight - 1) / blockHeight
 
-		if len(m.ifds[index].OriginalTileOffsets) > 0 {
-			blockOffsets = make([]uint32, len(m.ifds[index].OriginalTileOffsets))
-			for i, off := range m.ifds[index].OriginalTileOffsets {
+		if len(ifd.OriginalTileOffsets) > 0 {
+			blockOffsets = make([]uint32, len(ifd.OriginalTileOffsets))
+			for i, off := range ifd.OriginalTileOffsets {
 				blockOffsets[i] = uint32(off)
 			}
 		}
 
-		if len(m.ifds[index].TileByteCounts) > 0 {
-			blockCounts = m.ifds[index].TileByteCounts
+		if len(ifd.TileByteCounts) > 0 {
+			blockCounts = ifd.TileByteCounts
 		}
 	} else {
-		if m.ifds[index].RowsPerStrip != nil {
-			blockHeight = int(*m.ifds[index].RowsPerStrip)
+		if ifd.RowsPerStrip != nil {
+			blockHeight = int(*ifd.RowsPerStrip)
 		}
 
 		blocksDown = (height + blockHeight - 1) / blockHeight
 
-		if len(m.ifds[index].StripOffsets) > 0 {
-			blockOffsets = m.ifds[index].StripOffsets
+		if len(ifd.StripOffsets) > 0 {
+			blockOffsets = ifd.StripOffsets
 		}
 
-		if len(m.ifds[index].StripByteCounts) > 0 {
-			blockCounts = m.ifds[index].StripByteCounts
+		if len(ifd.StripByteCounts) > 0 {
+			blockCounts = ifd.StripByteCounts
 		}
 	}
 	var buf []byte
@@ -254,479 +272,78 @@ func (m Reader) readData(index int) (data interface{}, rect image.Rectangle, err
 			}
 			offset := int64(blockOffsets[j*blocksAcross+i])
 			n := int64(blockCounts[j*blocksAcross+i])
-			switch compressionType {
-			case CTNone:
-				buf = make([]byte, n)
-				_, err = m.ifds[index].r.ReadAt(buf, offset)
-			case CTG3:
-				inv := m.ifds[index].PhotometricInterpretation == PI_WhiteIsZero
-				order := ccittFillOrder(uint(m.ifds[index].FillOrder))
-				r := ccitt.NewReader(io.NewSectionReader(m.ifds[index].r, offset, n), order, ccitt.Group3, blkW, blkH, &ccitt.Options{Invert: inv, Align: false})
-				buf, err = ioutil.ReadAll(r)
-			case CTG4:
-				inv := m.ifds[index].PhotometricInterpretation == PI_WhiteIsZero
-				order := ccittFillOrder(uint(m.ifds[index].FillOrder))
-				r := ccitt.NewReader(io.NewSectionReader(m.ifds[index].r, offset, n), order, ccitt.Group4, blkW, blkH, &ccitt.Options{Invert: inv, Align: false})
-				buf, err = ioutil.ReadAll(r)
-			case CTLZW:
-				r := lzw.NewReader(io.NewSectionReader(m.ifds[index].r, offset, n), true)
-				defer r.Close()
-				buf, err = io.ReadAll(r)
-
-				if err != nil {
-					println(err)
-				}
-			case CTDeflate, CTDeflateOld:
-				r, err := zlib.NewReader(io.NewSectionReader(m.ifds[index].r, offset, n))
-				if err != nil {
-					return nil, image.Rectangle{}, err
+			sparse := n == 0
+			if sparse {
+				buf = ifd.emptyBlock(blockWidth, blockHeight)
+			} else {
+				switch compressionType {
+				case CTNone:
+					buf = make([]byte, n)
+					_, err = ifd.r.ReadAt(buf, offset)
+				case CTG3:
+					inv := ifd.PhotometricInterpretation == PI_WhiteIsZero
+					order := ccittFillOrder(uint(ifd.FillOrder))
+					r := ccitt.NewReader(io.NewSectionReader(ifd.r, offset, n), order, ccitt.Group3, blkW, blkH, &ccitt.Options{Invert: inv, Align: false})
+					buf, err = ioutil.ReadAll(r)
+				case CTG4:
+					inv := ifd.PhotometricInterpretation == PI_WhiteIsZero
+					order := ccittFillOrder(uint(ifd.FillOrder))
+					r := ccitt.NewReader(io.NewSectionReader(ifd.r, offset, n), order, ccitt.Group4, blkW, blkH, &ccitt.Options{Invert: inv, Align: false})
+					buf, err = ioutil.ReadAll(r)
+				case CTLZW:
+					r := lzw.NewReader(io.NewSectionReader(ifd.r, offset, n), true)
+					buf, err = io.ReadAll(r)
+					r.Close()
+				case CTDeflate, CTDeflateOld:
+					var r io.ReadCloser
+					if r, err = zlib.NewReader(io.NewSectionReader(ifd.r, offset, n)); err == nil {
+						buf, err = io.ReadAll(r)
+						r.Close()
+					}
+				case CTPackBits:
+					buf, err = unpackBits(io.NewSectionReader(ifd.r, offset, n))
+				case CTJPEG:
+					buf = make([]byte, n)
+					if _, err = ifd.r.ReadAt(buf, offset); err == nil {
+						buf, err = ifd.decodeJPEG(buf, blockWidth, blkH)
+					}
+				default:
+					err = fmt.Errorf("unsupported compression value %d", compressionType)
 				}
-				buf, err = io.ReadAll(r)
 				if err != nil {
-					return nil, image.Rectangle{}, err
+					return nil, rect, err
 				}
-				r.Close()
-			case CTPackBits:
-				buf, err = unpackBits(io.NewSectionReader(m.ifds[index].r, offset, n))
-			default:
-				err = fmt.Errorf("unsupported compression value %d", compressionType)
 			}
 
 			xmin := i * blockWidth
 			ymin := j * blockHeight
-			xmax := xmin + blkW
-			ymax := ymin + blkH
-
-			xmax = minInt(xmax, width)
-			ymax = minInt(ymax, height)
-
-			off = 0
-
-			if m.ifds[index].Predictor == PredictorHorizontal {
-				if bitsPerSample[0] == 16 {
-					var off int
-					spp := len(bitsPerSample)
-					bpp := spp * 2
-					for y := ymin; y < ymax; y++ {
-						off += spp * 2
-						for x := 0; x < (xmax-xmin-1)*bpp; x += 2 {
-							v0 := m.ifds[index].r.ByteOrder().Uint16(buf[off-bpp : off-bpp+2])
-							v1 := m.ifds[index].r.ByteOrder().Uint16(buf[off : off+2])
-							m.ifds[index].r.ByteOrder().PutUint16(buf[off:off+2], v1+v0)
-							off += 2
-						}
-					}
-				} else if bitsPerSample[0] == 8 {
-					var off int
-					spp := len(bitsPerSample)
-					for y := ymin; y < ymax; y++ {
-						off += spp
-						for x := 0; x < (xmax-xmin-1)*spp; x++ {
-							buf[off] += buf[off-spp]
-							off++
-						}
-					}
-				}
-			}
-			var mode ImageMode
-			PhotometricInterp := m.ifds[index].PhotometricInterpretation
-			var palette color.Palette
-
-			switch PhotometricInterp {
-			case PI_RGB:
-				if bitsPerSample[0] == 16 {
-					for _, b := range bitsPerSample {
-						if b != 16 {
-							err = errors.New("wrong number of samples for 16bit RGB")
-							return
-						}
-					}
-				} else {
-					for _, b := range bitsPerSample {
-						if b != 8 {
-							err = errors.New("wrong number of samples for 8bit RGB")
-							return
-						}
-					}
-				}
+			xmax := minInt(xmin+blkW, width)
+			ymax := minInt(ymin+blkH, height)
 
-				switch len(bitsPerSample) {
-				case 3:
-					mode = IRGB
-				case 4:
-					es := uint16(0)
-					if len(m.ifds[index].ExtraSamples) > 0 {
-						es = m.ifds[index].ExtraSamples[0]
-					}
-					switch es {
-					case 1:
-						mode = IRGBA
-					case 2:
-						mode = INRGBA
-					default:
-						err = errors.New("wrong number of samples for RGB")
-						return
-					}
-				default:
-					err = errors.New("wrong number of samples for RGB")
-					return
-				}
-			case PI_Paletted:
-				mode = IPaletted
-				if len(m.ifds[index].Colormap) > 0 {
-					val := m.ifds[index].Colormap
-					numcolors := len(val) / 3
-					if len(val)%3 != 0 || numcolors <= 0 || numcolors > 256 {
-						return nil, image.Rectangle{}, errors.New("bad ColorMap length")
-					}
-					palette = make(color.Palette, numcolors)
-					for i := 0; i < numcolors; i++ {
-						red := uint8(float64(val[i]) / 65535.0 * 255.0)
-						green := uint8(float64(val[i+numcolors]) / 65535.0 * 255.0)
-						blue := uint8(float64(val[i+2*numcolors]) / 65535.0 * 255.0)
-						a := uint8(255)
-						palette[i] = color.RGBA{R: red, G: green, B: blue, A: a}
-					}
-				} else {
-					err = errors.New("could not locate the colour map tag")
-					return
-				}
-			case PI_WhiteIsZero:
-				mode = IGrayInvert
-			case PI_BlackIsZero:
-				mode = IGray
-			default:
-				err = errors.New("unsupported image format")
-				return
+			if !sparse && ifd.Predictor == PredictorHorizontal {
+				decodeHorizontalPredictor(buf, blkW, spp, bitsPerSample, order)
+			}
+			if bitsPerSample < 8 {
+				buf = unpackSamples(buf, bitsPerSample, blkW*spp)
+			}
+			switch ifd.PhotometricInterpretation {
+			case PI_YCbCr:
+				h, v := data.subsampling()
+				buf = decodeYCbCr(buf, blkW, blkH, h, v)
+			case PI_CIELab:
+				decodeCIELab(buf)
 			}
 
-			switch mode {
-			case IGray, IGrayInvert:
-				switch SampleFormat {
-				case 1:
-					switch bitsPerSample[0] {
-					case 8:
-						var ddata []uint8
-						if data == nil {
-							ddata = make([]uint8, width*height)
-							data = ddata
-						} else {
-							ddata = data.([]uint8)
-						}
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								i := y*width + x
-								ddata[i] = buf[off]
-								off++
-							}
-						}
-					case 16:
-						var ddata []uint16
-						if data == nil {
-							ddata = make([]uint16, width*height)
-							data = ddata
-						} else {
-							ddata = data.([]uint16)
-						}
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := m.ifds[index].r.ByteOrder().Uint16(buf[off : off+2])
-								i := y*width + x
-								ddata[i] = value
-								off += 2
-							}
-						}
-					case 32:
-						var ddata []uint32
-						if data == nil {
-							ddata = make([]uint32, width*height)
-							data = ddata
-						} else {
-							ddata = data.([]uint32)
-						}
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := m.ifds[index].r.ByteOrder().Uint32(buf[off : off+4])
-								i := y*width + x
-								ddata[i] = value
-								off += 4
-							}
-						}
-					case 64:
-						var ddata []uint64
-						if data == nil {
-							ddata = make([]uint64, width*height)
-							data = ddata
-						} else {
-							ddata = data.([]uint64)
-						}
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := m.ifds[index].r.ByteOrder().Uint64(buf[off : off+8])
-								i := y*width + x
-								ddata[i] = value
-								off += 8
-							}
-						}
-					default:
-						err = errors.New("unsupported data format")
-						return
-					}
-				case 2:
-					switch bitsPerSample[0] {
-					case 8:
-						var ddata []int8
-						if data == nil {
-							ddata = make([]int8, width*height)
-							data = ddata
-						} else {
-							ddata = data.([]int8)
-						}
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								i := y*width + x
-								ddata[i] = int8(buf[off])
-								off++
-							}
-						}
-					case 16:
-						var ddata []int16
-						if data == nil {
-							ddata = make([]int16, width*height)
-							data = ddata
-						} else {
-							ddata = data.([]int16)
-						}
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := int16(m.ifds[index].r.ByteOrder().Uint16(buf[off : off+2]))
-								i := y*width + x
-								ddata[i] = value
-								off += 2
-							}
-						}
-					case 32:
-						var ddata []int32
-						if data == nil {
-							ddata = make([]int32, width*height)
-							data = ddata
-						} else {
-							ddata = data.([]int32)
-						}
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := int32(m.ifds[index].r.ByteOrder().Uint32(buf[off : off+4]))
-								i := y*width + x
-								ddata[i] = value
-								off += 4
-							}
-						}
-					case 64:
-						var ddata []int64
-						if data == nil {
-							ddata = make([]int64, width*height)
-							data = ddata
-						} else {
-							ddata = data.([]int64)
-						}
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := int64(m.ifds[index].r.ByteOrder().Uint64(buf[off : off+8]))
-								i := y*width + x
-								ddata[i] = value
-								off += 8
-							}
-						}
-					default:
-						err = errors.New("unsupported data format")
-						return
-					}
-				case 3:
-					switch bitsPerSample[0] {
-					case 32:
-						var ddata []float32
-						if data == nil {
-							ddata = make([]float32, width*height)
-							data = ddata
-						} else {
-							ddata = data.([]float32)
-						}
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								if off <= len(buf) {
-									bits := m.ifds[index].r.ByteOrder().Uint32(buf[off : off+4])
-									float := math.Float32frombits(bits)
-									i := y*width + x
-									ddata[i] = float
-									off += 4
-								}
-							}
-						}
-					case 64:
-						var ddata []float64
-						if data == nil {
-							ddata = make([]float64, width*height)
-							data = ddata
-						} else {
-							ddata = data.([]float64)
-						}
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								if off <= len(buf) {
-									bits := m.ifds[index].r.ByteOrder().Uint64(buf[off : off+8])
-									float := math.Float64frombits(bits)
-									i := y*width + x
-									ddata[i] = float
-									off += 8
-								}
-							}
-						}
-					default:
-						err = errors.New("unsupported data format")
-						return
-					}
-				default:
-					err = errors.New("unsupported sample format")
-					return
-				}
-			case IPaletted:
-				var ddata *image.Paletted
-				if data == nil {
-					ddata = image.NewPaletted(image.Rect(0, 0, width, height), palette)
-					data = ddata
-				} else {
-					ddata = data.(*image.Paletted)
-				}
-				for y := ymin; y < ymax; y++ {
-					for x := xmin; x < xmax; x++ {
-						val := int(buf[off])
-						ddata.Set(x, y, palette[val])
-						off++
-					}
-				}
-			case IRGB:
-				if bitsPerSample[0] == 8 {
-					var ddata *image.RGBA
-					if data == nil {
-						ddata = image.NewRGBA(image.Rect(0, 0, width, height))
-						data = ddata
-					} else {
-						ddata = data.(*image.RGBA)
-					}
-					for y := ymin; y < ymax; y++ {
-						for x := xmin; x < xmax; x++ {
-							red := uint8(buf[off])
-							green := uint8(buf[off+1])
-							blue := uint8(buf[off+2])
-							a := uint8(255)
-							off += 3
-							ddata.Set(x, y, color.RGBA{R: red, G: green, B: blue, A: a})
-						}
-					}
-				} else if bitsPerSample[0] == 16 {
-					var ddata *image.RGBA64
-					if data == nil {
-						ddata = image.NewRGBA64(image.Rect(0, 0, width, height))
-						data = ddata
-					} else {
-						ddata = data.(*image.RGBA64)
-					}
-					for y := ymin; y < ymax; y++ {
-						for x := xmin; x < xmax; x++ {
-							red := m.ifds[index].r.ByteOrder().Uint16(buf[off+0 : off+2])
-							green := m.ifds[index].r.ByteOrder().Uint16(buf[off+2 : off+4])
-							blue := m.ifds[index].r.ByteOrder().Uint16(buf[off+4 : off+6])
-							a := uint16(255)
-							off += 6
-							ddata.Set(x, y, color.RGBA64{R: red, G: green, B: blue, A: a})
-						}
-					}
-				} else {
-					err = errors.New("unsupported data format")
-					return
-				}
-			case INRGBA:
-				if bitsPerSample[0] == 8 {
-					var ddata *image.NRGBA
-					if data == nil {
-						ddata = image.NewNRGBA(image.Rect(0, 0, width, height))
-						data = ddata
-					} else {
-						ddata = data.(*image.NRGBA)
-					}
-					for y := ymin; y < ymax; y++ {
-						for x := xmin; x < xmax; x++ {
-							red := uint8(buf[off])
-							green := uint8(buf[off+1])
-							blue := uint8(buf[off+2])
-							a := uint8(buf[off+3])
-							off += 4
-							ddata.Set(x, y, color.NRGBA{R: red, G: green, B: blue, A: a})
-						}
-					}
-				} else if bitsPerSample[0] == 16 {
-					var ddata *image.NRGBA64
-					if data == nil {
-						ddata = image.NewNRGBA64(image.Rect(0, 0, width, height))
-						data = ddata
-					} else {
-						ddata = data.(*image.NRGBA64)
-					}
-					for y := ymin; y < ymax; y++ {
-						for x := xmin; x < xmax; x++ {
-							red := m.ifds[index].r.ByteOrder().Uint16(buf[off+0 : off+2])
-							green := m.ifds[index].r.ByteOrder().Uint16(buf[off+2 : off+4])
-							blue := m.ifds[index].r.ByteOrder().Uint16(buf[off+4 : off+6])
-							a := m.ifds[index].r.ByteOrder().Uint16(buf[off+6 : off+8])
-							off += 8
-							ddata.Set(x, y, color.NRGBA64{R: red, G: green, B: blue, A: a})
-						}
-					}
-				} else {
-					err = errors.New("unsupported data format")
-					return
-				}
-			case IRGBA:
-				if bitsPerSample[0] == 16 {
-					var ddata *image.RGBA64
-					if data == nil {
-						ddata = image.NewRGBA64(image.Rect(0, 0, width, height))
-						data = ddata
-					} else {
-						ddata = data.(*image.RGBA64)
-					}
-					for y := ymin; y < ymax; y++ {
-						for x := xmin; x < xmax; x++ {
-							red := m.ifds[index].r.ByteOrder().Uint16(buf[off+0 : off+2])
-							green := m.ifds[index].r.ByteOrder().Uint16(buf[off+2 : off+4])
-							blue := m.ifds[index].r.ByteOrder().Uint16(buf[off+4 : off+6])
-							a := m.ifds[index].r.ByteOrder().Uint16(buf[off+6 : off+8])
-							off += 8
-							ddata.Set(x, y, color.RGBA64{R: red, G: green, B: blue, A: a})
-						}
-					}
-				} else {
-					var ddata *image.RGBA
-					if data == nil {
-						ddata = image.NewRGBA(image.Rect(0, 0, width, height))
-						data = ddata
-					} else {
-						ddata = data.(*image.RGBA)
-					}
-					for y := ymin; y < ymax; y++ {
-						for x := xmin; x < xmax; x++ {
-							red := uint8(buf[off])
-							green := uint8(buf[off+1])
-							blue := uint8(buf[off+2])
-							a := uint8(buf[off+3])
-							off += 4
-							ddata.Set(x, y, color.NRGBA{R: red, G: green, B: blue, A: a})
-						}
-					}
+			row := (xmax - xmin) * pixelSize
+			for y := ymin; y < ymax; y++ {
+				off := (y - ymin) * blkW * pixelSize
+				if off+row > len(buf) {
+					return nil, rect, errors.New("truncated image block")
 				}
+				copy(data.Pix[(y*width+xmin)*pixelSize:], buf[off:off+row])
 			}
 		}
 	}
 
-	return
+	return data, rect, nil
 }
@@ -0,0 +1,237 @@
+package cog
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+type RPCModel struct {
+	ErrBias     float64
+	ErrRand     float64
+	LineOff     float64
+	SampOff     float64
+	LatOff      float64
+	LongOff     float64
+	HeightOff   float64
+	LineScale   float64
+	SampScale   float64
+	LatScale    float64
+	LongScale   float64
+	HeightScale float64
+	LineNum     [20]float64
+	LineDen     [20]float64
+	SampNum     [20]float64
+	SampDen     [20]float64
+}
+
+func ParseRPC(coeffs []float64) (*RPCModel, error) {
+	if len(coeffs) != 92 {
+		return nil, errors.New("RPC tag must contain 92 coefficients")
+	}
+	m := &RPCModel{
+		ErrBias:     coeffs[0],
+		ErrRand:     coeffs[1],
+		LineOff:     coeffs[2],
+		SampOff:     coeffs[3],
+		LatOff:      coeffs[4],
+		LongOff:     coeffs[5],
+		HeightOff:   coeffs[6],
+		LineScale:   coeffs[7],
+		SampScale:   coeffs[8],
+		LatScale:    coeffs[9],
+		LongScale:   coeffs[10],
+		HeightScale: coeffs[11],
+	}
+	copy(m.LineNum[:], coeffs[12:32])
+	copy(m.LineDen[:], coeffs[32:52])
+	copy(m.SampNum[:], coeffs[52:72])
+	copy(m.SampDen[:], coeffs[72:92])
+	if m.LineScale == 0 || m.SampScale == 0 || m.LatScale == 0 || m.LongScale == 0 || m.HeightScale == 0 {
+		return nil, errors.New("RPC scale factors must not be zero")
+	}
+	return m, nil
+}
+
+func (m *RPCModel) Coefficients() []float64 {
+	coeffs := []float64{
+		m.ErrBias, m.ErrRand,
+		m.LineOff, m.SampOff, m.LatOff, m.LongOff, m.HeightOff,
+		m.LineScale, m.SampScale, m.LatScale, m.LongScale, m.HeightScale,
+	}
+	coeffs = append(coeffs, m.LineNum[:]...)
+	coeffs = append(coeffs, m.LineDen[:]...)
+	coeffs = append(coeffs, m.SampNum[:]...)
+	coeffs = append(coeffs, m.SampDen[:]...)
+	return coeffs
+}
+
+func (ifd *IFD) RPCModel() (*RPCModel, error) {
+	if len(ifd.RPCs) == 0 {
+		return nil, errors.New("no RPC tag")
+	}
+	return ParseRPC(ifd.RPCs)
+}
+
+func (ifd *IFD) SetRPCModel(m *RPCModel) {
+	ifd.RPCs = m.Coefficients()
+}
+
+func (m Reader) GetRPC(i int) (*RPCModel, error) {
+	return m.ifds[i].RPCModel()
+}
+
+func rpcTerms(l, p, h float64) [20]float64 {
+	return [20]float64{
+		1, l, p, h,
+		l * p, l * h, p * h, l * l, p * p, h * h,
+		p * l * h, l * l * l, l * p * p, l * h * h, l * l * p,
+		p * p * p, p * h * h, l * l * h, p * p * h, h * h * h,
+	}
+}
+
+func rpcPolynomial(c *[20]float64, t *[20]float64) float64 {
+	var v float64
+	for i := range c {
+		v += c[i] * t[i]
+	}
+	return v
+}
+
+func (m *RPCModel) GroundToImage(lon, lat, height float64) (sample, line float64) {
+	l := (lon - m.LongOff) / m.LongScale
+	p := (lat - m.LatOff) / m.LatScale
+	h := (height - m.HeightOff) / m.HeightScale
+	t := rpcTerms(l, p, h)
+	line = rpcPolynomial(&m.LineNum, &t)/rpcPolynomial(&m.LineDen, &t)*m.LineScale + m.LineOff
+	sample = rpcPolynomial(&m.SampNum, &t)/rpcPolynomial(&m.SampDen, &t)*m.SampScale + m.SampOff
+	return
+}
+
+func (m *RPCModel) ImageToGround(sample, line, height float64) (lon, lat float64, err error) {
+	lon, lat = m.LongOff, m.LatOff
+	dl := m.LongScale * 1e-6
+	dp := m.LatScale * 1e-6
+	for i := 0; i < 50; i++ {
+		s0, l0 := m.GroundToImage(lon, lat, height)
+		es, el := sample-s0, line-l0
+		if math.Abs(es) < 1e-8 && math.Abs(el) < 1e-8 {
+			return lon, lat, nil
+		}
+		s1, l1 := m.GroundToImage(lon+dl, lat, height)
+		s2, l2 := m.GroundToImage(lon, lat+dp, height)
+		a, b := (s1-s0)/dl, (s2-s0)/dp
+		c, d := (l1-l0)/dl, (l2-l0)/dp
+		det := a*d - b*c
+		if det == 0 || math.IsNaN(det) {
+			return 0, 0, errors.New("RPC inverse did not converge")
+		}
+		lon += (d*es - b*el) / det
+		lat += (a*el - c*es) / det
+	}
+	s0, l0 := m.GroundToImage(lon, lat, height)
+	if math.Abs(sample-s0) > 1e-3 || math.Abs(line-l0) > 1e-3 {
+		return 0, 0, errors.New("RPC inverse did not converge")
+	}
+	return lon, lat, nil
+}
+
+type HeightSource interface {
+	Height(lon, lat float64) (float64, bool)
+}
+
+type gridHeightSource struct {
+	buf    *pixelBuffer
+	inv    GeoTransform
+	noData *float64
+}
+
+// NewGridHeightSource samples heights from a DEM grid. Cells equal to noData
+// or NaN are voids, and points next to them have no height.
+func NewGridHeightSource(data interface{}, rect image.Rectangle, gt GeoTransform, noData *float64) (HeightSource, error) {
+	buf, err := newPixelBuffer(data, rect.Dx(), rect.Dy())
+	if err != nil {
+		return nil, err
+	}
+	inv, err := gt.Inverse()
+	if err != nil {
+		return nil, err
+	}
+	return &gridHeightSource{buf: buf, inv: inv, noData: noData}, nil
+}
+
+func (s *gridHeightSource) Height(lon, lat float64) (float64, bool) {
+	px, py := s.inv.PixelToWorld(lon, lat)
+	x, y := px-0.5, py-0.5
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+	clamp := func(v, max int) int {
+		if v < 0 {
+			return 0
+		}
+		if v >= max {
+			return max - 1
+		}
+		return v
+	}
+	if px < 0 || py < 0 || px > float64(s.buf.width) || py > float64(s.buf.height) {
+		return 0, false
+	}
+	x1, y1 := clamp(x0+1, s.buf.width), clamp(y0+1, s.buf.height)
+	x0, y0 = clamp(x0, s.buf.width), clamp(y0, s.buf.height)
+	v00, v10 := s.buf.at(x0, y0, 0), s.buf.at(x1, y0, 0)
+	v01, v11 := s.buf.at(x0, y1, 0), s.buf.at(x1, y1, 0)
+	for _, v := range [4]float64{v00, v10, v01, v11} {
+		if math.IsNaN(v) || isNoData(s.noData, v) {
+			return 0, false
+		}
+	}
+	top := v00 + (v10-v00)*fx
+	bottom := v01 + (v11-v01)*fx
+	return top + (bottom-top)*fy, true
+}
+
+type RPCTransform struct {
+	Model  *RPCModel
+	Height float64
+	DEM    HeightSource
+}
+
+func (t *RPCTransform) height(lon, lat float64) float64 {
+	if t.DEM != nil {
+		if h, ok := t.DEM.Height(lon, lat); ok {
+			return h
+		}
+	}
+	return t.Height
+}
+
+func (t *RPCTransform) WorldToPixel(lon, lat float64) (float64, float64) {
+	s, l := t.Model.GroundToImage(lon, lat, t.height(lon, lat))
+	return s + 0.5, l + 0.5
+}
+
+func (t *RPCTransform) PixelToWorld(px, py float64) (float64, float64) {
+	h := t.Height
+	var lon, lat float64
+	for i := 0; i < 10; i++ {
+		var err error
+		lon, lat, err = t.Model.ImageToGround(px-0.5, py-0.5, h)
+		if err != nil {
+			return math.NaN(), math.NaN()
+		}
+		if t.DEM == nil {
+			break
+		}
+		nh := t.height(lon, lat)
+		if math.Abs(nh-h) < 0.01 {
+			break
+		}
+		h = nh
+	}
+	return lon, lat
+}
+
+func NewRPCWarper(src WarpSource, model *RPCModel, dem HeightSource, resampling ResampleMethod) *Warper {
+	return NewWarper(src, &RPCTransform{Model: model, Height: model.HeightOff, DEM: dem}, epsg4326, resampling)
+}
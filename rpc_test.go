@@ -0,0 +1,91 @@
+package cog
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	vec2d "github.com/flywave/go3d/float64/vec2"
+)
+
+func testRPCModel() *RPCModel {
+	m := &RPCModel{
+		LineOff: 500, SampOff: 500, LatOff: 30, LongOff: 120, HeightOff: 100,
+		LineScale: 500, SampScale: 500, LatScale: 0.05, LongScale: 0.05, HeightScale: 500,
+	}
+	m.LineNum[2] = -1
+	m.LineNum[8] = 0.01
+	m.LineDen[0] = 1
+	m.SampNum[1] = 1
+	m.SampNum[3] = 0.1
+	m.SampDen[0] = 1
+	return m
+}
+
+func TestRPCRoundTrip(t *testing.T) {
+	m := testRPCModel()
+	parsed, err := ParseRPC(m.Coefficients())
+	if err != nil || *parsed != *m {
+		t.Fatal("coefficients do not round trip")
+	}
+
+	s, l := m.GroundToImage(120.01, 30.02, 350)
+	lon, lat, err := m.ImageToGround(s, l, 350)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(lon-120.01) > 1e-9 || math.Abs(lat-30.02) > 1e-9 {
+		t.Fatalf("got %v,%v", lon, lat)
+	}
+}
+
+func TestRPCTransformWithDEM(t *testing.T) {
+	m := testRPCModel()
+	dem := make([]float32, 10*10)
+	for i := range dem {
+		dem[i] = 600
+	}
+	noData := -32768.0
+	dem[99] = float32(noData)
+	hs, err := NewGridHeightSource(dem, image.Rect(0, 0, 10, 10), GeoTransform{119.9, 0.02, 0, 30.1, 0, -0.02}, &noData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hs.Height(120.075, 29.925); ok {
+		t.Fatal("height interpolated from a void cell")
+	}
+	tr := &RPCTransform{Model: m, DEM: hs}
+
+	px, py := tr.WorldToPixel(120.01, 29.99)
+	es, el := m.GroundToImage(120.01, 29.99, 600)
+	if math.Abs(px-es-0.5) > 1e-9 || math.Abs(py-el-0.5) > 1e-9 {
+		t.FailNow()
+	}
+	lon, lat := tr.PixelToWorld(px, py)
+	if math.Abs(lon-120.01) > 1e-7 || math.Abs(lat-29.99) > 1e-7 {
+		t.Fatalf("got %v,%v", lon, lat)
+	}
+}
+
+func TestRPCWarp(t *testing.T) {
+	data := make([]uint16, 1000*1000)
+	for i := range data {
+		data[i] = 7
+	}
+	w := NewRPCWarper(NewMemorySource(data, image.Rect(0, 0, 1000, 1000)), testRPCModel(), nil, ResampleNearest)
+	ext := w.Extent(nil)
+	if !ext.ContainsPoint(&vec2d.T{120, 30}) {
+		t.Fatalf("unexpected extent %v", ext)
+	}
+	box := vec2d.Rect{Min: vec2d.T{119.99, 29.99}, Max: vec2d.T{120.01, 30.01}}
+	src, err := w.Warp(box, nil, [2]int{32, 32}, CTNone)
+	if err != nil || src == nil {
+		t.FailNow()
+	}
+	out, _ := src.Data().Data()
+	for _, v := range out.([]uint16) {
+		if v != 7 {
+			t.FailNow()
+		}
+	}
+}
@@ -4,8 +4,9 @@ import (
 	"bytes"
 	"compress/zlib"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"image"
-	"image/color"
 	"io"
 
 	"github.com/hhrutter/lzw"
@@ -15,12 +16,13 @@ type TileSource interface {
 	Bounds() image.Rectangle
 	Encode(w io.Writer, ifd *IFD) (uint32, *IFD, error)
 	Reset()
-	Data() interface{}
+	Data() *Raster
 	CompressionType() CompressionType
 }
 
 type RawSource struct {
-	dataOrImage               interface{} // []uint16 |  []uint32 | []uint64 | []int16 |  []int32 | []int64 | []float32 | []float64 | image.Image
+	raster                    *Raster
+	err                       error
 	rect                      *image.Rectangle
 	ctype                     CompressionType
 	photometricInterpretation uint32
@@ -29,41 +31,69 @@ type RawSource struct {
 	extraSamples              uint16
 	colorMap                  []uint16
 	sampleFormat              []uint16
+	predictor                 Predictor
+	sampleBits                int
+	subsampling               []uint16
 	enc                       binary.ByteOrder
 }
 
+// NewSource accepts a *Raster, a typed slice covering rect or an image,
+// which are converted to a Raster. Conversion errors are returned by
+// Encode.
 func NewSource(data interface{}, rect *image.Rectangle, ctype CompressionType) *RawSource {
-	return &RawSource{dataOrImage: data, rect: rect, ctype: ctype, enc: tiffByteOrder}
+	s := &RawSource{rect: rect, ctype: ctype, enc: tiffByteOrder}
+	var size image.Point
+	if rect != nil {
+		size = rect.Size()
+	}
+	s.raster, s.err = toRaster(data, size.X, size.Y, false)
+	return s
 }
 
 func (s *RawSource) Reset() {
-	s.dataOrImage = nil
+	s.raster = nil
 }
 
-func (s *RawSource) Data() interface{} {
-	return s.dataOrImage
+func (s *RawSource) Data() *Raster {
+	return s.raster
 }
 
 func (s *RawSource) CompressionType() CompressionType {
 	return s.ctype
 }
 
+func (s *RawSource) SetCompressionType(ctype CompressionType) {
+	s.ctype = ctype
+}
+
+func (s *RawSource) SetPredictor(p Predictor) {
+	s.predictor = p
+}
+
+func (s *RawSource) SetByteOrder(enc binary.ByteOrder) {
+	s.enc = enc
+}
+
+// SetBitsPerSample packs single band Byte data, such as []uint8,
+// *image.Gray and *image.Paletted, into 1, 2 or 4 bit samples. Other
+// depths keep one byte per sample.
+func (s *RawSource) SetBitsPerSample(bits int) {
+	s.sampleBits = bits
+}
+
+func (s *RawSource) depth() int {
+	switch s.sampleBits {
+	case 1, 2, 4:
+		if r := s.raster; r != nil && r.Bands == 1 && r.Type == DTByte {
+			return s.sampleBits
+		}
+	}
+	return 8
+}
+
 func (s *RawSource) Bounds() image.Rectangle {
-	switch m := s.dataOrImage.(type) {
-	case *image.Paletted:
-		return m.Bounds()
-	case *image.Gray:
-		return m.Bounds()
-	case *image.Gray16:
-		return m.Bounds()
-	case *image.RGBA64:
-		return m.Bounds()
-	case *image.NRGBA64:
-		return m.Bounds()
-	case *image.RGBA:
-		return m.Bounds()
-	case *image.NRGBA:
-		return m.Bounds()
+	if s.raster != nil {
+		return s.raster.Bounds()
 	}
 	if s.rect != nil {
 		return *s.rect
@@ -72,185 +102,69 @@ func (s *RawSource) Bounds() image.Rectangle {
 }
 
 func (s *RawSource) Encode(w io.Writer, ifd *IFD) (uint32, *IFD, error) {
+	if s.err != nil {
+		return 0, nil, s.err
+	}
+	if s.raster == nil {
+		return 0, nil, errors.New("source has no data")
+	}
 	d := s.Bounds().Size()
 
 	compression := s.ctype
 
 	var buf bytes.Buffer
 	var dst io.Writer
-	var imageLen int
 
 	switch compression {
 	case CTNone:
-		dst = w
-		switch s.dataOrImage.(type) {
-		case *image.Paletted:
-			imageLen = d.X * d.Y * 1
-		case *image.Gray:
-			imageLen = d.X * d.Y * 1
-		case *image.Gray16:
-			imageLen = d.X * d.Y * 2
-		case *image.RGBA64:
-			imageLen = d.X * d.Y * 8
-		case *image.NRGBA64:
-			imageLen = d.X * d.Y * 8
-		case *image.RGBA:
-			imageLen = d.X * d.Y * 4
-		case *image.NRGBA:
-			imageLen = d.X * d.Y * 4
-		case []uint16:
-			imageLen = d.X * d.Y * 2
-		case []uint32:
-			imageLen = d.X * d.Y * 4
-		case []uint64:
-			imageLen = d.X * d.Y * 8
-		case []int16:
-			imageLen = d.X * d.Y * 2
-		case []int32:
-			imageLen = d.X * d.Y * 4
-		case []int64:
-			imageLen = d.X * d.Y * 8
-		case []float32:
-			imageLen = d.X * d.Y * 4
-		case []float64:
-			imageLen = d.X * d.Y * 8
-		default:
-			imageLen = d.X * d.Y * 4
-		}
-		err := binary.Write(w, s.enc, uint32(imageLen+8))
-		if err != nil {
-			return 0, nil, err
-		}
+		dst = &buf
 	case CTDeflate:
 		dst = zlib.NewWriter(&buf)
 	case CTLZW:
 		dst = lzw.NewWriter(&buf, true)
 	}
 
-	s.photometricInterpretation = uint32(PI_RGB)
-	s.samplesPerPixel = uint32(4)
-	s.bitsPerSample = []uint16{8, 8, 8, 8}
-	s.extraSamples = uint16(0)
-	s.colorMap = []uint16{}
-	s.sampleFormat = []uint16{}
-
-	var err error
-	switch m := s.dataOrImage.(type) {
-	case *image.Paletted:
-		s.photometricInterpretation = PI_Paletted
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{8}
-		s.colorMap = make([]uint16, 256*3)
-		for i := 0; i < 256 && i < len(m.Palette); i++ {
-			r, g, b, _ := m.Palette[i].RGBA()
-			s.colorMap[i+0*256] = uint16(r)
-			s.colorMap[i+1*256] = uint16(g)
-			s.colorMap[i+2*256] = uint16(b)
-		}
-		err = encodeGray(dst, m.Pix, d.X, d.Y, m.Stride)
-	case *image.Gray:
-		s.photometricInterpretation = PI_BlackIsZero
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{8}
-		s.sampleFormat = []uint16{1}
-		err = encodeGray(dst, m.Pix, d.X, d.Y, m.Stride)
-	case *image.Gray16:
-		s.photometricInterpretation = PI_BlackIsZero
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{16}
-		s.sampleFormat = []uint16{1}
-		err = encodeGray16(dst, m.Pix, d.X, d.Y, m.Stride)
-	case *image.NRGBA64:
-		s.extraSamples = 2
-		s.bitsPerSample = []uint16{16, 16, 16, 16}
-		err = encodeRGBA64(dst, m.Pix, d.X, d.Y, m.Stride)
-	case *image.RGBA64:
-		s.extraSamples = 1
-		s.bitsPerSample = []uint16{16, 16, 16, 16}
-		err = encodeRGBA64(dst, m.Pix, d.X, d.Y, m.Stride)
-	case *image.NRGBA:
-		s.extraSamples = 2
-		err = encodeRGBA(dst, m.Pix, d.X, d.Y, m.Stride)
-	case *image.RGBA:
-		s.extraSamples = 1
-		err = encodeRGBA(dst, m.Pix, d.X, d.Y, m.Stride)
-	case []uint16:
-		s.photometricInterpretation = PI_BlackIsZero
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{16}
-		s.sampleFormat = []uint16{1}
-		err = encodeUInt16(dst, s.Bounds(), s.enc, m)
-	case []uint32:
-		s.photometricInterpretation = PI_BlackIsZero
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{32}
-		s.sampleFormat = []uint16{1}
-		err = encodeUInt32(dst, s.Bounds(), s.enc, m)
-	case []uint64:
-		s.photometricInterpretation = PI_BlackIsZero
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{64}
-		s.sampleFormat = []uint16{1}
-		err = encodeUInt64(dst, s.Bounds(), s.enc, m)
-	case []int16:
-		s.photometricInterpretation = PI_BlackIsZero
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{16}
-		s.sampleFormat = []uint16{2}
-		err = encodeInt16(dst, s.Bounds(), s.enc, m)
-	case []int32:
-		s.photometricInterpretation = PI_BlackIsZero
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{32}
-		s.sampleFormat = []uint16{2}
-		err = encodeInt32(dst, s.Bounds(), s.enc, m)
-	case []int64:
-		s.photometricInterpretation = PI_BlackIsZero
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{64}
-		s.sampleFormat = []uint16{2}
-		err = encodeInt64(dst, s.Bounds(), s.enc, m)
-	case []float32:
-		s.photometricInterpretation = PI_BlackIsZero
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{32}
-		s.sampleFormat = []uint16{3}
-		err = encodeFloat32(dst, s.Bounds(), s.enc, m)
-	case []float64:
-		s.photometricInterpretation = PI_BlackIsZero
-		s.samplesPerPixel = 1
-		s.bitsPerSample = []uint16{64}
-		s.sampleFormat = []uint16{3}
-		err = encodeFloat64(dst, s.Bounds(), s.enc, m)
-	default:
-		s.extraSamples = 1
-		err = encode(dst, m.(image.Image))
+	var cw io.Writer
+	var raw *bytes.Buffer
+	if compression != CTNone && s.predictor == PredictorHorizontal {
+		cw, raw = dst, &bytes.Buffer{}
+		dst = raw
 	}
-	if err != nil {
+
+	if err := s.encodeRaster(dst, s.raster); err != nil {
 		return 0, nil, err
 	}
 
-	if compression != CTNone {
-		if err = dst.(io.Closer).Close(); err != nil {
-			return 0, nil, err
+	predictor := Predictor(PredictorNone)
+	if raw != nil {
+		if horizontalPredictable(s.bitsPerSample) {
+			encodeHorizontalPredictor(raw.Bytes(), d.X, len(s.bitsPerSample), int(s.bitsPerSample[0]), s.enc)
+			predictor = PredictorHorizontal
 		}
-		imageLen = buf.Len()
-		if err = binary.Write(w, s.enc, uint32(imageLen+8)); err != nil {
+		if _, err := raw.WriteTo(cw); err != nil {
 			return 0, nil, err
 		}
-		if _, err = buf.WriteTo(w); err != nil {
-			return 0, nil, err
-		}
-		if err = binary.Write(w, s.enc, uint32(0)); err != nil {
+		dst = cw
+	}
+
+	if compression != CTNone {
+		if err := dst.(io.Closer).Close(); err != nil {
 			return 0, nil, err
 		}
 	}
+	imageLen := buf.Len()
+	if _, err := buf.WriteTo(w); err != nil {
+		return 0, nil, err
+	}
 
 	if ifd != nil {
 		ifd.TileWidth = uint16(d.X)
 		ifd.TileLength = uint16(d.Y)
 		ifd.BitsPerSample = s.bitsPerSample
 		ifd.Compression = uint16(s.ctype)
+		if predictor != PredictorNone {
+			ifd.Predictor = uint16(predictor)
+		}
 		ifd.PhotometricInterpretation = uint16(s.photometricInterpretation)
 		ifd.SamplesPerPixel = uint16(s.samplesPerPixel)
 
@@ -262,6 +176,7 @@ func (s *RawSource) Encode(w io.Writer, ifd *IFD) (uint32, *IFD, error) {
 		if s.extraSamples > 0 {
 			ifd.ExtraSamples = []uint16{s.extraSamples}
 		}
+		ifd.YCbCrSubSampling = s.subsampling
 		if s.samplesPerPixel > 1 {
 			ifd.PlanarConfiguration = 1
 		}
@@ -270,6 +185,61 @@ func (s *RawSource) Encode(w io.Writer, ifd *IFD) (uint32, *IFD, error) {
 	return uint32(imageLen), ifd, nil
 }
 
+// encodeRaster sets the photometric tags for the colour interpretation of
+// r and writes its samples.
+func (s *RawSource) encodeRaster(w io.Writer, r *Raster) error {
+	t := r.Type
+	s.photometricInterpretation = PI_BlackIsZero
+	s.samplesPerPixel = uint32(r.Bands)
+	s.bitsPerSample = make([]uint16, r.Bands)
+	s.sampleFormat = make([]uint16, r.Bands)
+	for b := range s.bitsPerSample {
+		s.bitsPerSample[b] = t.BitsPerSample()
+		s.sampleFormat[b] = t.SampleFormat()
+	}
+	s.extraSamples = 0
+	s.colorMap = nil
+	s.subsampling = nil
+
+	rgb := (r.Bands == 3 || r.Bands == 4) && (t == DTByte || t == DTUInt16)
+	switch {
+	case r.Color == ColorPaletted && r.Bands == 1 && t == DTByte:
+		s.photometricInterpretation = PI_Paletted
+		n := 1 << uint(s.depth())
+		s.colorMap = make([]uint16, n*3)
+		for i := 0; i < n && i < len(r.Palette); i++ {
+			cr, cg, cb, _ := r.Palette[i].RGBA()
+			s.colorMap[i+0*n] = uint16(cr)
+			s.colorMap[i+1*n] = uint16(cg)
+			s.colorMap[i+2*n] = uint16(cb)
+		}
+	case r.Color == ColorCMYK && r.Bands == 4 && t == DTByte:
+		s.photometricInterpretation = PI_CMYK
+	case r.Color == ColorYCbCr && r.Bands == 3 && t == DTByte:
+		s.photometricInterpretation = PI_YCbCr
+		h, v := r.subsampling()
+		s.subsampling = []uint16{uint16(h), uint16(v)}
+		return encodeYCbCr(w, r)
+	case r.Color == ColorPremultiplied && r.Bands == 4 && rgb:
+		s.photometricInterpretation = PI_RGB
+		s.extraSamples = 1
+	case r.Bands == 1:
+	case rgb:
+		s.photometricInterpretation = PI_RGB
+		if r.Bands == 4 {
+			s.extraSamples = 2
+		}
+	default:
+		return fmt.Errorf("cannot encode a %d band %s raster", r.Bands, t)
+	}
+	if bits := s.depth(); bits < 8 {
+		s.bitsPerSample[0] = uint16(bits)
+		return packSamples(w, r.Pix, r.Width, r.Height, r.Width, bits)
+	}
+	_, err := w.Write(r.Bytes(s.enc))
+	return err
+}
+
 type TiffSource struct {
 	RawSource
 	ifd *IFD
@@ -277,48 +247,22 @@ type TiffSource struct {
 
 func NewTiffSource(ifd *IFD, enc binary.ByteOrder) *TiffSource {
 	m := &Reader{ifds: []*IFD{ifd}}
-	d, r, _ := m.readData(0)
-	return &TiffSource{ifd: ifd, RawSource: RawSource{dataOrImage: d, rect: &r, ctype: CompressionType(ifd.Compression), enc: enc}}
+	d, r, err := m.readData(0)
+	src := &TiffSource{ifd: ifd, RawSource: RawSource{raster: d, err: err, rect: &r, ctype: CompressionType(ifd.Compression), enc: enc}}
+	if len(ifd.BitsPerSample) > 0 {
+		src.SetBitsPerSample(int(ifd.BitsPerSample[0]))
+	}
+	return src
 }
 
-func (s *TiffSource) Bounds() image.Rectangle {
-	return image.Rect(0, 0, int(s.ifd.TileWidth), int(s.ifd.TileLength))
+func (s *TiffSource) Encode(w io.Writer, ifd *IFD) (uint32, *IFD, error) {
+	n, ifd, err := s.RawSource.Encode(w, ifd)
+	if err == nil && ifd != nil {
+		ifd.CopyTags(s.ifd)
+	}
+	return n, ifd, err
 }
 
-func getZeroDate(src TileSource) interface{} {
-	w, h := src.Bounds().Dx(), src.Bounds().Dy()
-	switch src.Data().(type) {
-	case *image.Paletted:
-		return image.NewPaletted(src.Bounds(), color.Palette{})
-	case *image.Gray:
-		return image.NewGray(src.Bounds())
-	case *image.Gray16:
-		return image.NewGray16(src.Bounds())
-	case *image.NRGBA:
-		return image.NewNRGBA(src.Bounds())
-	case *image.NRGBA64:
-		return image.NewNRGBA64(src.Bounds())
-	case *image.RGBA:
-		return image.NewRGBA(src.Bounds())
-	case *image.RGBA64:
-		return image.NewRGBA64(src.Bounds())
-	case []uint16:
-		return make([]uint16, w*h)
-	case []uint32:
-		return make([]uint16, w*h)
-	case []uint64:
-		return make([]uint16, w*h)
-	case []int16:
-		return make([]uint16, w*h)
-	case []int32:
-		return make([]uint16, w*h)
-	case []int64:
-		return make([]uint16, w*h)
-	case []float32:
-		return make([]uint16, w*h)
-	case []float64:
-		return make([]uint16, w*h)
-	default:
-		return nil
-	}
+func (s *TiffSource) Bounds() image.Rectangle {
+	return image.Rect(0, 0, int(s.ifd.TileWidth), int(s.ifd.TileLength))
 }
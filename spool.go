@@ -0,0 +1,106 @@
+package cog
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+type Spool interface {
+	io.ReadWriteSeeker
+	io.Closer
+}
+
+type SpoolFactory func() (Spool, error)
+
+type fileSpool struct {
+	*os.File
+}
+
+func (s *fileSpool) Close() error {
+	err := s.File.Close()
+	if rerr := os.Remove(s.File.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func FileSpool(dir string) SpoolFactory {
+	return func() (Spool, error) {
+		f, err := ioutil.TempFile(dir, "tile-")
+		if err != nil {
+			return nil, err
+		}
+		return &fileSpool{File: f}, nil
+	}
+}
+
+type memorySpool struct {
+	buf []byte
+	pos int64
+}
+
+func MemorySpool() SpoolFactory {
+	return func() (Spool, error) {
+		return &memorySpool{}, nil
+	}
+}
+
+func (s *memorySpool) Write(p []byte) (int, error) {
+	end := s.pos + int64(len(p))
+	if end > int64(len(s.buf)) {
+		if end > int64(cap(s.buf)) {
+			nb := make([]byte, end, 2*end)
+			copy(nb, s.buf)
+			s.buf = nb
+		}
+		s.buf = s.buf[:end]
+	}
+	copy(s.buf[s.pos:], p)
+	s.pos = end
+	return len(p), nil
+}
+
+func (s *memorySpool) Read(p []byte) (int, error) {
+	if s.pos >= int64(len(s.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.buf[s.pos:])
+	s.pos += int64(n)
+	return n, nil
+}
+
+func (s *memorySpool) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = s.pos + offset
+	case io.SeekEnd:
+		pos = int64(len(s.buf)) + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.pos = pos
+	return pos, nil
+}
+
+func (s *memorySpool) Close() error {
+	s.buf = nil
+	s.pos = 0
+	return nil
+}
+
+type countingWriter struct {
+	n uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += uint64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,217 @@
+package cog
+
+import (
+	"errors"
+	"math"
+)
+
+type Histogram struct {
+	Min    float64
+	Max    float64
+	Counts []uint64
+}
+
+type Statistics struct {
+	Minimum     float64
+	Maximum     float64
+	Mean        float64
+	StdDev      float64
+	ValidCount  uint64
+	TotalCount  uint64
+	Approximate bool
+	Histogram   *Histogram
+}
+
+func (s Statistics) ValidPercent() float64 {
+	if s.TotalCount == 0 {
+		return 0
+	}
+	return 100 * float64(s.ValidCount) / float64(s.TotalCount)
+}
+
+func (s Statistics) BandStatistics() *BandStatistics {
+	pct := s.ValidPercent()
+	return &BandStatistics{Minimum: s.Minimum, Maximum: s.Maximum, Mean: s.Mean, StdDev: s.StdDev, ValidPercent: &pct, Approximate: s.Approximate}
+}
+
+func (h *Histogram) bucket(v float64) int {
+	n := len(h.Counts)
+	if h.Max <= h.Min {
+		return 0
+	}
+	i := int(float64(n) * (v - h.Min) / (h.Max - h.Min))
+	if i >= n {
+		i = n - 1
+	}
+	return i
+}
+
+type statsAccumulator struct {
+	noData  *float64
+	buckets int
+	bands   []Statistics
+	m2      []float64
+}
+
+func newStatsAccumulator(noData *float64, buckets int) *statsAccumulator {
+	return &statsAccumulator{noData: noData, buckets: buckets}
+}
+
+func (a *statsAccumulator) valid(v float64) bool {
+	return !math.IsNaN(v) && !isNoData(a.noData, v)
+}
+
+func (a *statsAccumulator) add(p *pixelBuffer) {
+	for len(a.bands) < p.bands {
+		a.bands = append(a.bands, Statistics{Minimum: math.Inf(1), Maximum: math.Inf(-1)})
+		a.m2 = append(a.m2, 0)
+	}
+	n := p.width * p.height
+	for b := 0; b < p.bands; b++ {
+		s := &a.bands[b]
+		s.TotalCount += uint64(n)
+		for i := 0; i < n; i++ {
+			v := p.get(i, b)
+			if !a.valid(v) {
+				continue
+			}
+			s.ValidCount++
+			d := v - s.Mean
+			s.Mean += d / float64(s.ValidCount)
+			a.m2[b] += d * (v - s.Mean)
+			if v < s.Minimum {
+				s.Minimum = v
+			}
+			if v > s.Maximum {
+				s.Maximum = v
+			}
+		}
+	}
+}
+
+func (a *statsAccumulator) addHistogram(p *pixelBuffer) {
+	n := p.width * p.height
+	for b := 0; b < p.bands && b < len(a.bands); b++ {
+		h := a.bands[b].Histogram
+		if h == nil {
+			continue
+		}
+		for i := 0; i < n; i++ {
+			if v := p.get(i, b); a.valid(v) {
+				h.Counts[h.bucket(v)]++
+			}
+		}
+	}
+}
+
+func (a *statsAccumulator) finish() []Statistics {
+	for b := range a.bands {
+		s := &a.bands[b]
+		if s.ValidCount == 0 {
+			s.Minimum, s.Maximum = 0, 0
+			continue
+		}
+		s.StdDev = math.Sqrt(a.m2[b] / float64(s.ValidCount))
+		if a.buckets > 0 {
+			s.Histogram = &Histogram{Min: s.Minimum, Max: s.Maximum, Counts: make([]uint64, a.buckets)}
+		}
+	}
+	return a.bands
+}
+
+func computeStatistics(bufs []*pixelBuffer, noData *float64, buckets int) []Statistics {
+	a := newStatsAccumulator(noData, buckets)
+	for _, p := range bufs {
+		a.add(p)
+	}
+	stats := a.finish()
+	if buckets > 0 {
+		for _, p := range bufs {
+			a.addHistogram(p)
+		}
+	}
+	return stats
+}
+
+func ComputeStatistics(data interface{}, width, height int, noData *float64, buckets int) ([]Statistics, error) {
+	p, err := newPixelBuffer(data, width, height)
+	if err != nil {
+		return nil, err
+	}
+	return computeStatistics([]*pixelBuffer{p}, noData, buckets), nil
+}
+
+func (m Reader) GetStatistics(i int, approx bool, buckets int) ([]Statistics, error) {
+	idx := i
+	if approx {
+		idx = m.smallestOverview(i)
+	}
+	if idx >= len(m.Data) || m.Data[idx] == nil {
+		return nil, errors.New("image data not loaded")
+	}
+	size := m.GetSize(idx)
+	stats, err := ComputeStatistics(m.Data[idx], int(size[0]), int(size[1]), m.GetNoData(i), buckets)
+	if err != nil {
+		return nil, err
+	}
+	for b := range stats {
+		stats[b].Approximate = idx != i
+	}
+	return stats, nil
+}
+
+func (m Reader) smallestOverview(i int) int {
+	best := i
+	for j := i + 1; j < len(m.ifds); j++ {
+		ifd := m.ifds[j]
+		if ifd.NewSubfileType&subfileMask != 0 {
+			continue
+		}
+		if ifd.NewSubfileType&subfileReduced == 0 {
+			break
+		}
+		if ifd.ImageWidth*ifd.ImageLength < m.ifds[best].ImageWidth*m.ifds[best].ImageLength {
+			best = j
+		}
+	}
+	return best
+}
+
+// ComputeStatistics computes the statistics of the layer, counting tiles
+// without a source as nodata.
+func (l *TileLayer) ComputeStatistics(buckets int) ([]Statistics, error) {
+	var bufs []*pixelBuffer
+	missing := 0
+	for _, t := range l.tiles {
+		if t.Src == nil || t.Src.Data() == nil {
+			missing++
+			continue
+		}
+		size := t.Src.Bounds().Size()
+		p, err := newPixelBuffer(t.Src.Data(), size.X, size.Y)
+		if err != nil {
+			return nil, err
+		}
+		bufs = append(bufs, p)
+	}
+	if len(bufs) == 0 {
+		return nil, errors.New("layer has no tile data")
+	}
+	stats := computeStatistics(bufs, l.noData, buckets)
+	size := l.GetTileSize()
+	for b := range stats {
+		stats[b].TotalCount += uint64(missing) * uint64(size[0]) * uint64(size[1])
+	}
+	return stats, nil
+}
+
+func (ifd *IFD) SetStatistics(stats []Statistics) error {
+	md, err := ifd.Metadata()
+	if err != nil {
+		return err
+	}
+	for b, s := range stats {
+		md.Band(b).Statistics = s.BandStatistics()
+	}
+	return ifd.SetMetadata(md)
+}
@@ -0,0 +1,109 @@
+package cog
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestComputeStatistics(t *testing.T) {
+	nd := -9999.0
+	data := []float32{1, 2, 3, 4, float32(math.NaN()), -9999}
+	stats, err := ComputeStatistics(data, 3, 2, &nd, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := stats[0]
+	if s.Minimum != 1 || s.Maximum != 4 || s.Mean != 2.5 || math.Abs(s.StdDev-math.Sqrt(1.25)) > 1e-12 {
+		t.Fatalf("unexpected statistics %+v", s)
+	}
+	if s.ValidCount != 4 || s.TotalCount != 6 || math.Abs(s.ValidPercent()-400.0/6) > 1e-12 {
+		t.Fatalf("unexpected counts %+v", s)
+	}
+	if h := s.Histogram; h == nil || len(h.Counts) != 4 || h.Counts[0] != 1 || h.Counts[3] != 1 {
+		t.Fatalf("unexpected histogram %+v", s.Histogram)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	copy(img.Pix, []uint8{10, 20, 30, 255, 30, 40, 50, 255})
+	stats, err = ComputeStatistics(img, 2, 1, nil, 0)
+	if err != nil || len(stats) != 4 {
+		t.Fatal(err)
+	}
+	if stats[0].Mean != 20 || stats[2].Maximum != 50 || stats[3].StdDev != 0 || stats[0].Histogram != nil {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+}
+
+func TestReaderStatistics(t *testing.T) {
+	r := Read("./test_data/cog_ext_multi.tif")
+	exact, err := r.GetStatistics(0, false, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approx, err := r.GetStatistics(0, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exact[0].Approximate || !approx[0].Approximate || exact[0].TotalCount != 256*256 || approx[0].TotalCount >= exact[0].TotalCount {
+		t.Fatalf("exact %+v approx %+v", exact[0], approx[0])
+	}
+	var n uint64
+	for _, c := range exact[0].Histogram.Counts {
+		n += c
+	}
+	if n != exact[0].ValidCount {
+		t.Fatalf("histogram holds %d values, expected %d", n, exact[0].ValidCount)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteTo(buf, []*TileLayer{buildTestLayer(t)}, &Options{Statistics: true}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ReadInfo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := ReadFrom(bytes.NewReader(buf.Bytes())).GetMetadata(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	band := info.Bands[0]
+	if band.Minimum == nil || band.Mean == nil || md.Bands[0].Statistics.ValidPercent == nil {
+		t.Fatalf("statistics not written: %+v", band)
+	}
+}
+
+func TestLayerStatistics(t *testing.T) {
+	base := buildTestLayer(t)
+	full, err := base.ComputeStatistics(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.tiles[0].Src = nil
+	partial, err := base.ComputeStatistics(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partial[0].TotalCount != full[0].TotalCount || partial[0].ValidPercent() >= full[0].ValidPercent() {
+		t.Fatalf("missing tile not counted: full %+v partial %+v", full[0], partial[0])
+	}
+
+	rect := image.Rect(0, 0, 512, 512)
+	ov := NewTileLayer(base.box, 13, base.grid)
+	for _, tile := range ov.tiles {
+		tile.Src = NewSource(make([]float32, 512*512), &rect, CTLZW)
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteTo(buf, []*TileLayer{base, ov}, &Options{ApproxStatistics: true}); err != nil {
+		t.Fatal(err)
+	}
+	md, err := ReadFrom(bytes.NewReader(buf.Bytes())).GetMetadata(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := md.Bands[0].Statistics; s == nil || !s.Approximate {
+		t.Fatalf("approximate statistics not flagged: %+v", s)
+	}
+}
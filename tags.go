@@ -0,0 +1,510 @@
+package cog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/tiff"
+)
+
+const (
+	TypeByte      = tByte
+	TypeASCII     = tAscii
+	TypeShort     = tShort
+	TypeLong      = tLong
+	TypeRational  = tRational
+	TypeSByte     = tSByte
+	TypeUndefined = tUndefined
+	TypeSShort    = tSShort
+	TypeSLong     = tSLong
+	TypeSRational = tSRational
+	TypeFloat     = tFloat
+	TypeDouble    = tDouble
+	TypeLong8     = tLong8
+	TypeSLong8    = tSLong8
+)
+
+// Tag is a TIFF field that is not part of the image structure. Value holds
+// a string for ASCII, []byte for BYTE and UNDEFINED, and a slice of the
+// matching Go type otherwise. RATIONAL and SRATIONAL values are stored as
+// numerator/denominator pairs in []uint32 and []int32.
+type Tag struct {
+	ID    uint16
+	Type  uint16
+	Value interface{}
+}
+
+var (
+	ifdFieldTags = ifdStructTags()
+
+	// tags that point into the source file and would dangle once copied
+	pointerTags = map[uint16]bool{330: true, 513: true, 514: true, 34665: true, 34853: true, 40965: true}
+)
+
+func ifdStructTags() map[uint16]bool {
+	ids := make(map[uint16]bool)
+	t := reflect.TypeOf(IFD{})
+	for i := 0; i < t.NumField(); i++ {
+		for _, opt := range strings.Split(t.Field(i).Tag.Get("tiff"), ",") {
+			if strings.HasPrefix(opt, "tag=") {
+				if id, err := strconv.ParseUint(opt[4:], 10, 16); err == nil {
+					ids[uint16(id)] = true
+				}
+			}
+		}
+	}
+	return ids
+}
+
+func tagTypeSize(typ uint16) int {
+	switch typ {
+	case tByte, tAscii, tSByte, tUndefined:
+		return 1
+	case tShort, tSShort:
+		return 2
+	case tLong, tSLong, tFloat:
+		return 4
+	case tRational, tSRational, tDouble, tLong8, tSLong8:
+		return 8
+	}
+	return 0
+}
+
+func (t Tag) encode(enc binary.ByteOrder) (uint64, []byte, error) {
+	var data []byte
+	var n int
+	switch v := t.Value.(type) {
+	case string:
+		if t.Type != tAscii {
+			break
+		}
+		data = append([]byte(v), 0)
+		n = len(data)
+	case []byte:
+		if t.Type != tByte && t.Type != tUndefined {
+			break
+		}
+		data = append([]byte(nil), v...)
+		n = len(data)
+	case []int8:
+		if t.Type != tSByte {
+			break
+		}
+		for _, x := range v {
+			data = append(data, byte(x))
+		}
+		n = len(data)
+	case []uint16:
+		if t.Type != tShort {
+			break
+		}
+		data = make([]byte, 2*len(v))
+		for i, x := range v {
+			enc.PutUint16(data[2*i:], x)
+		}
+		n = len(v)
+	case []int16:
+		if t.Type != tSShort {
+			break
+		}
+		data = make([]byte, 2*len(v))
+		for i, x := range v {
+			enc.PutUint16(data[2*i:], uint16(x))
+		}
+		n = len(v)
+	case []uint32:
+		if t.Type != tLong && t.Type != tRational {
+			break
+		}
+		data = make([]byte, 4*len(v))
+		for i, x := range v {
+			enc.PutUint32(data[4*i:], x)
+		}
+		n = len(v)
+	case []int32:
+		if t.Type != tSLong && t.Type != tSRational {
+			break
+		}
+		data = make([]byte, 4*len(v))
+		for i, x := range v {
+			enc.PutUint32(data[4*i:], uint32(x))
+		}
+		n = len(v)
+	case []uint64:
+		if t.Type != tLong8 {
+			break
+		}
+		data = make([]byte, 8*len(v))
+		for i, x := range v {
+			enc.PutUint64(data[8*i:], x)
+		}
+		n = len(v)
+	case []int64:
+		if t.Type != tSLong8 {
+			break
+		}
+		data = make([]byte, 8*len(v))
+		for i, x := range v {
+			enc.PutUint64(data[8*i:], uint64(x))
+		}
+		n = len(v)
+	case []float32:
+		if t.Type != tFloat {
+			break
+		}
+		data = make([]byte, 4*len(v))
+		for i, x := range v {
+			enc.PutUint32(data[4*i:], math.Float32bits(x))
+		}
+		n = len(v)
+	case []float64:
+		if t.Type != tDouble {
+			break
+		}
+		data = make([]byte, 8*len(v))
+		for i, x := range v {
+			enc.PutUint64(data[8*i:], math.Float64bits(x))
+		}
+		n = len(v)
+	}
+	if data == nil {
+		return 0, nil, fmt.Errorf("tag %d: value %T does not match type %d", t.ID, t.Value, t.Type)
+	}
+	if t.Type == tRational || t.Type == tSRational {
+		if n%2 != 0 {
+			return 0, nil, fmt.Errorf("tag %d: rational value needs numerator/denominator pairs", t.ID)
+		}
+		n /= 2
+	}
+	if n == 0 {
+		return 0, nil, fmt.Errorf("tag %d: empty value", t.ID)
+	}
+	return uint64(n), data, nil
+}
+
+func (t Tag) dataLen() uint64 {
+	if s, ok := t.Value.(string); ok {
+		return uint64(len(s) + 1)
+	}
+	v := reflect.ValueOf(t.Value)
+	if v.Kind() != reflect.Slice {
+		return 0
+	}
+	return uint64(v.Len()) * uint64(v.Type().Elem().Size())
+}
+
+func decodeTag(f tiff.Field) (Tag, bool) {
+	t := Tag{ID: f.Tag().ID(), Type: f.Type().ID()}
+	raw := f.Value().Bytes()
+	enc := f.Value().Order()
+	size := tagTypeSize(t.Type)
+	if size == 0 || len(raw) < size*int(f.Count()) {
+		return t, false
+	}
+	n := int(f.Count())
+	raw = raw[:size*n]
+	switch t.Type {
+	case tAscii:
+		t.Value = strings.TrimRight(string(raw), "\x00")
+	case tByte, tUndefined:
+		t.Value = append([]byte(nil), raw...)
+	case tSByte:
+		v := make([]int8, n)
+		for i := range v {
+			v[i] = int8(raw[i])
+		}
+		t.Value = v
+	case tShort:
+		v := make([]uint16, n)
+		for i := range v {
+			v[i] = enc.Uint16(raw[2*i:])
+		}
+		t.Value = v
+	case tSShort:
+		v := make([]int16, n)
+		for i := range v {
+			v[i] = int16(enc.Uint16(raw[2*i:]))
+		}
+		t.Value = v
+	case tLong, tRational:
+		v := make([]uint32, len(raw)/4)
+		for i := range v {
+			v[i] = enc.Uint32(raw[4*i:])
+		}
+		t.Value = v
+	case tSLong, tSRational:
+		v := make([]int32, len(raw)/4)
+		for i := range v {
+			v[i] = int32(enc.Uint32(raw[4*i:]))
+		}
+		t.Value = v
+	case tFloat:
+		v := make([]float32, n)
+		for i := range v {
+			v[i] = math.Float32frombits(enc.Uint32(raw[4*i:]))
+		}
+		t.Value = v
+	case tDouble:
+		v := make([]float64, n)
+		for i := range v {
+			v[i] = math.Float64frombits(enc.Uint64(raw[8*i:]))
+		}
+		t.Value = v
+	case tLong8:
+		v := make([]uint64, n)
+		for i := range v {
+			v[i] = enc.Uint64(raw[8*i:])
+		}
+		t.Value = v
+	case tSLong8:
+		v := make([]int64, n)
+		for i := range v {
+			v[i] = int64(enc.Uint64(raw[8*i:]))
+		}
+		t.Value = v
+	default:
+		return t, false
+	}
+	return t, true
+}
+
+func loadExtraTags(ifd *IFD, tifd tiff.IFD) {
+	for _, f := range tifd.Fields() {
+		id := f.Tag().ID()
+		if ifdFieldTags[id] || pointerTags[id] {
+			continue
+		}
+		if t, ok := decodeTag(f); ok {
+			ifd.ExtraTags = append(ifd.ExtraTags, t)
+		}
+	}
+	sort.Slice(ifd.ExtraTags, func(i, j int) bool { return ifd.ExtraTags[i].ID < ifd.ExtraTags[j].ID })
+}
+
+func ratTag(id uint16, r *big.Rat) (Tag, bool) {
+	if r == nil || !r.Num().IsUint64() || r.Num().Uint64() > math.MaxUint32 || r.Denom().Uint64() > math.MaxUint32 {
+		return Tag{}, false
+	}
+	return Tag{ID: id, Type: tRational, Value: []uint32{uint32(r.Num().Uint64()), uint32(r.Denom().Uint64())}}, true
+}
+
+func shortTag(id uint16, v *uint16) (Tag, bool) {
+	if v == nil {
+		return Tag{}, false
+	}
+	return Tag{ID: id, Type: tShort, Value: []uint16{*v}}, true
+}
+
+func asciiTag(id uint16, v *string) (Tag, bool) {
+	if v == nil || *v == "" {
+		return Tag{}, false
+	}
+	return Tag{ID: id, Type: tAscii, Value: *v}, true
+}
+
+func (ifd *IFD) Tags() []Tag {
+	var tags []Tag
+	add := func(t Tag, ok bool) {
+		if ok {
+			tags = append(tags, t)
+		}
+	}
+	add(shortTag(TagSubfileType, ifd.SubfileType))
+	add(shortTag(TagThreshholding, ifd.Threshholding))
+	add(shortTag(TagCellWidth, ifd.CellWidth))
+	add(shortTag(TagCellLength, ifd.CellLength))
+	add(asciiTag(TagDocumentName, &ifd.DocumentName))
+	add(asciiTag(TagImageDescription, ifd.ImageDescription))
+	add(asciiTag(TagMake, ifd.Make))
+	add(asciiTag(TagModel, ifd.Model))
+	add(shortTag(TagOrientation, ifd.Orientation))
+	add(shortTag(TagMinSampleValue, ifd.MinSampleValue))
+	add(shortTag(TagMaxSampleValue, ifd.MaxSampleValue))
+	add(ratTag(TagXResolution, ifd.XResolution))
+	add(ratTag(TagYResolution, ifd.YResolution))
+	add(shortTag(TagGrayResponseUnit, ifd.GrayResponseUnit))
+	if len(ifd.GrayResponseCurve) > 0 {
+		add(Tag{ID: TagGrayResponseCurve, Type: tShort, Value: ifd.GrayResponseCurve}, true)
+	}
+	add(shortTag(TagResolutionUnit, ifd.ResolutionUnit))
+	add(asciiTag(TagSoftware, &ifd.Software))
+	add(asciiTag(TagDateTime, &ifd.DateTime))
+	add(asciiTag(TagArtist, ifd.Artist))
+	add(asciiTag(TagHostComputer, ifd.HostComputer))
+	add(asciiTag(TagCopyright, ifd.Copyright))
+	for _, t := range ifd.ExtraTags {
+		if _, _, err := t.encode(tiffByteOrder); err == nil {
+			tags = append(tags, t)
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].ID < tags[j].ID })
+	return tags
+}
+
+func (ifd *IFD) GetTag(id uint16) (Tag, bool) {
+	for _, t := range ifd.Tags() {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return Tag{}, false
+}
+
+// SetTag attaches t to the IFD, replacing any tag with the same ID.
+// Descriptive tags such as ImageDescription are stored in their IFD field,
+// tags describing the image structure are rejected.
+func (ifd *IFD) SetTag(t Tag) error {
+	if _, _, err := t.encode(tiffByteOrder); err != nil {
+		return err
+	}
+	if ifdFieldTags[t.ID] {
+		return ifd.setFieldTag(t)
+	}
+	if pointerTags[t.ID] {
+		return fmt.Errorf("tag %d holds file offsets and cannot be set", t.ID)
+	}
+	for i := range ifd.ExtraTags {
+		if ifd.ExtraTags[i].ID == t.ID {
+			ifd.ExtraTags[i] = t
+			return nil
+		}
+	}
+	ifd.ExtraTags = append(ifd.ExtraTags, t)
+	sort.SliceStable(ifd.ExtraTags, func(i, j int) bool { return ifd.ExtraTags[i].ID < ifd.ExtraTags[j].ID })
+	return nil
+}
+
+func (ifd *IFD) setFieldTag(t Tag) error {
+	str := func(dst **string) error {
+		s, ok := t.Value.(string)
+		if !ok {
+			return fmt.Errorf("tag %d must be ASCII", t.ID)
+		}
+		*dst = &s
+		return nil
+	}
+	short := func(dst **uint16) error {
+		v, ok := t.Value.([]uint16)
+		if !ok || len(v) != 1 {
+			return fmt.Errorf("tag %d must be a single SHORT", t.ID)
+		}
+		*dst = &v[0]
+		return nil
+	}
+	rat := func(dst **big.Rat) error {
+		v, ok := t.Value.([]uint32)
+		if !ok || t.Type != tRational || len(v) != 2 || v[1] == 0 {
+			return fmt.Errorf("tag %d must be a single RATIONAL", t.ID)
+		}
+		*dst = new(big.Rat).SetFrac64(int64(v[0]), int64(v[1]))
+		return nil
+	}
+	switch t.ID {
+	case TagSubfileType:
+		return short(&ifd.SubfileType)
+	case TagThreshholding:
+		return short(&ifd.Threshholding)
+	case TagCellWidth:
+		return short(&ifd.CellWidth)
+	case TagCellLength:
+		return short(&ifd.CellLength)
+	case TagImageDescription:
+		return str(&ifd.ImageDescription)
+	case TagMake:
+		return str(&ifd.Make)
+	case TagModel:
+		return str(&ifd.Model)
+	case TagOrientation:
+		return short(&ifd.Orientation)
+	case TagMinSampleValue:
+		return short(&ifd.MinSampleValue)
+	case TagMaxSampleValue:
+		return short(&ifd.MaxSampleValue)
+	case TagXResolution:
+		return rat(&ifd.XResolution)
+	case TagYResolution:
+		return rat(&ifd.YResolution)
+	case TagGrayResponseUnit:
+		return short(&ifd.GrayResponseUnit)
+	case TagResolutionUnit:
+		return short(&ifd.ResolutionUnit)
+	case TagArtist:
+		return str(&ifd.Artist)
+	case TagHostComputer:
+		return str(&ifd.HostComputer)
+	case TagCopyright:
+		return str(&ifd.Copyright)
+	case TagGrayResponseCurve:
+		v, ok := t.Value.([]uint16)
+		if !ok {
+			return fmt.Errorf("tag %d must be SHORT", t.ID)
+		}
+		ifd.GrayResponseCurve = v
+		return nil
+	}
+	s, ok := t.Value.(string)
+	if !ok {
+		return fmt.Errorf("tag %d must be ASCII", t.ID)
+	}
+	switch t.ID {
+	case TagDocumentName:
+		ifd.DocumentName = s
+	case TagSoftware:
+		ifd.Software = s
+	case TagDateTime:
+		ifd.DateTime = s
+	default:
+		return fmt.Errorf("tag %d describes the image structure and cannot be set", t.ID)
+	}
+	return nil
+}
+
+func (ifd *IFD) CopyTags(src *IFD) {
+	for _, t := range src.Tags() {
+		ifd.SetTag(t)
+	}
+}
+
+func (g *Writer) writeTag(w io.Writer, t Tag, tags *tagData) error {
+	n, data, err := t.encode(g.enc)
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	if g.bigtiff {
+		buf = make([]byte, 20)
+		g.enc.PutUint64(buf[4:12], n)
+		if len(data) <= 8 {
+			copy(buf[12:], data)
+		} else {
+			g.enc.PutUint64(buf[12:], tags.NextOffset())
+			tags.Write(data)
+			if len(data)%2 != 0 {
+				tags.WriteByte(0)
+			}
+		}
+	} else {
+		buf = make([]byte, 12)
+		g.enc.PutUint32(buf[4:8], uint32(n))
+		if len(data) <= 4 {
+			copy(buf[8:], data)
+		} else {
+			g.enc.PutUint32(buf[8:], uint32(tags.NextOffset()))
+			tags.Write(data)
+			if len(data)%2 != 0 {
+				tags.WriteByte(0)
+			}
+		}
+	}
+	g.enc.PutUint16(buf[0:2], t.ID)
+	g.enc.PutUint16(buf[2:4], t.Type)
+	_, err = w.Write(buf)
+	return err
+}
@@ -1,9 +1,18 @@
 package cog
 
 import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"reflect"
 	"testing"
 
 	"github.com/flywave/go-geo"
+
+	vec2d "github.com/flywave/go3d/float64/vec2"
 )
 
 func TestTiffWrite(t *testing.T) {
@@ -64,3 +73,348 @@ func TestTiffRead(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestBigEndianRoundTrip(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	gray := image.NewGray16(rect)
+	rgba := image.NewRGBA64(rect)
+	vals := make([]float32, 16*16)
+	for i := 0; i < 16*16; i++ {
+		gray.Pix[i*2], gray.Pix[i*2+1] = byte(i), byte(i*7)
+		for c := 0; c < 8; c++ {
+			rgba.Pix[i*8+c] = byte(i + c)
+		}
+		vals[i] = float32(i) * 0.5
+	}
+
+	for _, data := range []interface{}{gray, rgba, vals} {
+		for _, ctype := range []CompressionType{CTNone, CTLZW} {
+			var read []interface{}
+			for _, enc := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+				buf := &bytes.Buffer{}
+				opts := &Options{ByteOrder: enc}
+				if err := WriteTileTo(buf, NewSource(data, &rect, ctype), vec2d.Rect{Min: vec2d.T{116, 39}, Max: vec2d.T{117, 40}}, geo.NewProj(4326), [2]uint32{16, 16}, opts); err != nil {
+					t.Fatal(err)
+				}
+				if err := VerifyBlocks(bytes.NewReader(buf.Bytes())); err != nil {
+					t.Fatal(err)
+				}
+				r := ReadFrom(bytes.NewReader(buf.Bytes()))
+				if r == nil || r.Data[0] == nil {
+					t.FailNow()
+				}
+				read = append(read, r.Data[0].Bytes(binary.LittleEndian))
+				if enc == binary.BigEndian && string(buf.Bytes()[:2]) != "MM" {
+					t.FailNow()
+				}
+			}
+			if !reflect.DeepEqual(read[0], read[1]) {
+				t.Fatalf("%T compression %d: big-endian data differs", data, ctype)
+			}
+		}
+	}
+}
+
+func TestHorizontalPredictor(t *testing.T) {
+	rect := image.Rect(0, 0, 32, 32)
+	gray := image.NewGray16(rect)
+	rgba := image.NewNRGBA(rect)
+	for i := 0; i < 32*32; i++ {
+		gray.Pix[i*2], gray.Pix[i*2+1] = byte(i/7), byte(i*13)
+		for c := 0; c < 4; c++ {
+			rgba.Pix[i*4+c] = byte(i*3 + c*40)
+		}
+	}
+	box := vec2d.Rect{Min: vec2d.T{116, 39}, Max: vec2d.T{117, 40}}
+	for _, data := range []interface{}{gray, rgba} {
+		var read []interface{}
+		for _, p := range []Predictor{PredictorNone, PredictorHorizontal} {
+			buf := &bytes.Buffer{}
+			if err := WriteTileTo(buf, NewSource(data, &rect, CTLZW), box, geo.NewProj(4326), [2]uint32{32, 32}, &Options{Predictor: p}); err != nil {
+				t.Fatal(err)
+			}
+			r := ReadFrom(bytes.NewReader(buf.Bytes()))
+			if r.ifds[0].Predictor != uint16(p) && p != PredictorNone {
+				t.FailNow()
+			}
+			read = append(read, r.Data[0])
+		}
+		if !reflect.DeepEqual(read[0], read[1]) {
+			t.Fatalf("%T: predictor changed decoded data", data)
+		}
+	}
+}
+
+func TestSubByteSamples(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	palette := make(color.Palette, 16)
+	for i := range palette {
+		palette[i] = color.RGBA{R: uint8(i * 17), G: 0, B: uint8(255 - i*17), A: 255}
+	}
+	pal := image.NewPaletted(rect, palette)
+	mask := make([]uint8, 16*16)
+	levels := make([]uint8, 16*16)
+	signed := make([]int8, 16*16)
+	for i := 0; i < 16*16; i++ {
+		pal.Pix[i] = uint8(i % 16)
+		mask[i] = uint8(i / 3 % 2)
+		levels[i] = uint8(i % 4)
+		signed[i] = int8(i - 128)
+	}
+
+	box := vec2d.Rect{Min: vec2d.T{116, 39}, Max: vec2d.T{117, 40}}
+	for _, c := range []struct {
+		data interface{}
+		bits int
+	}{{mask, 1}, {levels, 2}, {pal, 4}, {signed, 8}} {
+		for _, ctype := range []CompressionType{CTNone, CTDeflate} {
+			src := NewSource(c.data, &rect, ctype)
+			src.SetBitsPerSample(c.bits)
+			buf := &bytes.Buffer{}
+			if err := WriteTileTo(buf, src, box, geo.NewProj(4326), [2]uint32{16, 16}, nil); err != nil {
+				t.Fatal(err)
+			}
+			r := ReadFrom(bytes.NewReader(buf.Bytes()))
+			if got := r.ifds[0].BitsPerSample; len(got) != 1 || int(got[0]) != c.bits {
+				t.Fatalf("%T: bits per sample %v, want %d", c.data, got, c.bits)
+			}
+			got, err := r.Data[0].Data()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := c.data
+			if p, ok := want.(*image.Paletted); ok {
+				want = p.Pix
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%T compression %d: decoded data differs", c.data, ctype)
+			}
+		}
+	}
+}
+
+func TestComplexSamples(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	ci16 := make([]ComplexInt16, 16*16)
+	ci32 := make([]ComplexInt32, 16*16)
+	cf32 := make([]complex64, 16*16)
+	cf64 := make([]complex128, 16*16)
+	for i := 0; i < 16*16; i++ {
+		ci16[i] = ComplexInt16{int16(i), int16(-i)}
+		ci32[i] = ComplexInt32{int32(i * 1000), int32(-i * 7)}
+		cf32[i] = complex(float32(i)*0.5, -float32(i)*0.25)
+		cf64[i] = complex(float64(i)/3, float64(i)*1e10)
+	}
+
+	box := vec2d.Rect{Min: vec2d.T{116, 39}, Max: vec2d.T{117, 40}}
+	for _, data := range []interface{}{ci16, ci32, cf32, cf64} {
+		for _, enc := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			buf := &bytes.Buffer{}
+			if err := WriteTileTo(buf, NewSource(data, &rect, CTDeflate), box, geo.NewProj(4326), [2]uint32{16, 16}, &Options{ByteOrder: enc}); err != nil {
+				t.Fatal(err)
+			}
+			r := ReadFrom(bytes.NewReader(buf.Bytes()))
+			if got, _ := r.Data[0].Data(); !reflect.DeepEqual(got, data) {
+				t.Fatalf("%T %v: decoded data differs", data, enc)
+			}
+		}
+	}
+}
+
+func TestPhotometricRoundTrip(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	cmyk := image.NewCMYK(rect)
+	for i := range cmyk.Pix {
+		cmyk.Pix[i] = byte(i * 5)
+	}
+	images := []interface{}{cmyk}
+	for _, ratio := range []image.YCbCrSubsampleRatio{image.YCbCrSubsampleRatio444, image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420, image.YCbCrSubsampleRatio410} {
+		ycc := image.NewYCbCr(rect, ratio)
+		for i := range ycc.Y {
+			ycc.Y[i] = byte(i * 3)
+		}
+		for i := range ycc.Cb {
+			ycc.Cb[i], ycc.Cr[i] = byte(i*7), byte(255-i)
+		}
+		images = append(images, ycc)
+	}
+
+	box := vec2d.Rect{Min: vec2d.T{116, 39}, Max: vec2d.T{117, 40}}
+	for _, data := range images {
+		for _, ctype := range []CompressionType{CTNone, CTLZW} {
+			buf := &bytes.Buffer{}
+			if err := WriteTileTo(buf, NewSource(data, &rect, ctype), box, geo.NewProj(4326), [2]uint32{16, 16}, nil); err != nil {
+				t.Fatal(err)
+			}
+			r := ReadFrom(bytes.NewReader(buf.Bytes()))
+			if img, _ := r.Data[0].Image(); !reflect.DeepEqual(img, data) {
+				t.Fatalf("%T compression %d: decoded data differs", data, ctype)
+			}
+		}
+	}
+
+	for _, c := range []struct {
+		l, a, b float64
+		rgb     [3]uint8
+	}{{100, 0, 0, [3]uint8{255, 255, 255}}, {0, 0, 0, [3]uint8{0, 0, 0}}, {53.24, 80.09, 67.20, [3]uint8{255, 0, 0}}} {
+		r, g, b := labToRGB(c.l, c.a, c.b)
+		if [3]uint8{r, g, b} != c.rgb {
+			t.Fatalf("lab %v %v %v: got %v %v %v", c.l, c.a, c.b, r, g, b)
+		}
+	}
+}
+
+// jpegTileSource writes an abbreviated JPEG stream, with its quantization
+// and Huffman tables moved to the JPEGTables tag.
+type jpegTileSource struct {
+	img *image.RGBA
+}
+
+func (s *jpegTileSource) Bounds() image.Rectangle          { return s.img.Bounds() }
+func (s *jpegTileSource) Reset()                           {}
+func (s *jpegTileSource) Data() *Raster                    { r, _ := RasterFrom(s.img, 0, 0); return r }
+func (s *jpegTileSource) CompressionType() CompressionType { return CTJPEG }
+
+func (s *jpegTileSource) Encode(w io.Writer, ifd *IFD) (uint32, *IFD, error) {
+	var full bytes.Buffer
+	if err := jpeg.Encode(&full, s.img, &jpeg.Options{Quality: 95}); err != nil {
+		return 0, nil, err
+	}
+	data := full.Bytes()
+	tables := []byte{0xff, 0xd8}
+	scan := []byte{0xff, 0xd8}
+	for off := 2; off < len(data); {
+		marker := data[off+1]
+		if marker == 0xda {
+			scan = append(scan, data[off:]...)
+			break
+		}
+		n := 2 + int(binary.BigEndian.Uint16(data[off+2:]))
+		if marker == 0xdb || marker == 0xc4 {
+			tables = append(tables, data[off:off+n]...)
+		} else {
+			scan = append(scan, data[off:off+n]...)
+		}
+		off += n
+	}
+	tables = append(tables, 0xff, 0xd9)
+
+	if _, err := w.Write(scan); err != nil {
+		return 0, nil, err
+	}
+	d := s.img.Bounds().Size()
+	ifd.TileWidth, ifd.TileLength = uint16(d.X), uint16(d.Y)
+	ifd.BitsPerSample = []uint16{8, 8, 8}
+	ifd.SamplesPerPixel = 3
+	ifd.Compression = uint16(CTJPEG)
+	ifd.PhotometricInterpretation = PI_YCbCr
+	ifd.YCbCrSubSampling = []uint16{2, 2}
+	ifd.PlanarConfiguration = 1
+	ifd.JPEGTables = tables
+	return uint32(len(scan)), ifd, nil
+}
+
+func TestJPEGYCbCr(t *testing.T) {
+	rect := image.Rect(0, 0, 32, 32)
+	img := image.NewRGBA(rect)
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 8), 128, 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	box := vec2d.Rect{Min: vec2d.T{116, 39}, Max: vec2d.T{117, 40}}
+	if err := WriteTileTo(buf, &jpegTileSource{img: img}, box, geo.NewProj(4326), [2]uint32{32, 32}, nil); err != nil {
+		t.Fatal(err)
+	}
+	r := ReadFrom(bytes.NewReader(buf.Bytes()))
+	decoded, _ := r.Data[0].Image()
+	ycc, ok := decoded.(*image.YCbCr)
+	if !ok || ycc.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		t.Fatalf("decoded %T", decoded)
+	}
+	diff := func(a, b uint32) uint32 {
+		if a > b {
+			return a - b
+		}
+		return b - a
+	}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			r0, g0, b0, _ := img.At(x, y).RGBA()
+			r1, g1, b1, _ := ycc.At(x, y).RGBA()
+			if diff(r0, r1)>>8 > 12 || diff(g0, g1)>>8 > 12 || diff(b0, b1)>>8 > 12 {
+				t.Fatalf("pixel %d,%d: got %v, want %v", x, y, ycc.At(x, y), img.At(x, y))
+			}
+		}
+	}
+}
+
+// paddedTiff builds a 20x20 Byte image stored as four 16x16 tiles, so the
+// right and bottom tiles are padded.
+func paddedTiff(predictor uint16) []byte {
+	const size, tile = 20, 16
+	var tiles [][]byte
+	for ty := 0; ty < 2; ty++ {
+		for tx := 0; tx < 2; tx++ {
+			b := make([]byte, tile*tile)
+			for y := 0; y < tile; y++ {
+				row := b[y*tile : (y+1)*tile]
+				for x := range row {
+					if px, py := tx*tile+x, ty*tile+y; px < size && py < size {
+						row[x] = byte(px*3 + py*7)
+					}
+				}
+				if predictor == PredictorHorizontal {
+					for x := tile - 1; x > 0; x-- {
+						row[x] -= row[x-1]
+					}
+				}
+			}
+			tiles = append(tiles, b)
+		}
+	}
+
+	type entry struct {
+		tag, typ uint16
+		n, v     uint32
+	}
+	arrays := uint32(8 + 2 + 11*12 + 4)
+	entries := []entry{
+		{256, tShort, 1, size}, {257, tShort, 1, size}, {258, tShort, 1, 8}, {259, tShort, 1, CTNone},
+		{262, tShort, 1, PI_BlackIsZero}, {277, tShort, 1, 1}, {317, tShort, 1, uint32(predictor)},
+		{322, tShort, 1, tile}, {323, tShort, 1, tile}, {324, tLong, 4, arrays}, {325, tLong, 4, arrays + 16},
+	}
+	buf := &bytes.Buffer{}
+	le := binary.LittleEndian
+	buf.WriteString("II*\x00")
+	binary.Write(buf, le, uint32(8))
+	binary.Write(buf, le, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(buf, le, e)
+	}
+	binary.Write(buf, le, uint32(0))
+	for i := range tiles {
+		binary.Write(buf, le, arrays+32+uint32(i*tile*tile))
+	}
+	for range tiles {
+		binary.Write(buf, le, uint32(tile*tile))
+	}
+	for _, b := range tiles {
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+func TestPaddedEdgeTiles(t *testing.T) {
+	for _, p := range []uint16{PredictorNone, PredictorHorizontal} {
+		r := ReadTiff(bytes.NewReader(paddedTiff(p)))
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 20; x++ {
+				if got := r.Data[0].At(x, y, 0); got != float64(byte(x*3+y*7)) {
+					t.Fatalf("predictor %d: pixel %d,%d is %v", p, x, y, got)
+				}
+			}
+		}
+	}
+}
@@ -14,41 +14,63 @@ import (
 
 type TileWriter struct {
 	Writer
-	src    TileSource
-	boxsrs geo.Proj
-	size   [2]uint32
-	box    vec2d.Rect
-	ifd    *IFD
-	noData *string
+	src       TileSource
+	boxsrs    geo.Proj
+	size      [2]uint32
+	box       vec2d.Rect
+	ifd       *IFD
+	noData    *float64
+	transform *GeoTransform
+	gcps      []GCP
+	rpc       *RPCModel
+	opts      *Options
 }
 
-func WriteTile(fileName string, src TileSource, box vec2d.Rect, boxsrs geo.Proj, size [2]uint32, noData *string) error {
-	w := NewTileWriter(src, tiffByteOrder, false, box, boxsrs, size, noData)
-
+func WriteTile(fileName string, src TileSource, box vec2d.Rect, boxsrs geo.Proj, size [2]uint32, noData *float64) error {
 	f, err := os.Create(fileName)
-
 	if err != nil {
 		return err
 	}
 
-	err = w.WriteData(f)
-
-	if err != nil {
-		return err
+	err = WriteTileTo(f, src, box, boxsrs, size, &Options{NoData: noData})
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
+	return err
+}
 
-	defer f.Close()
-
-	return nil
+func WriteTileTo(out io.Writer, src TileSource, box vec2d.Rect, boxsrs geo.Proj, size [2]uint32, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	w := NewTileWriter(src, opts.byteOrder(), opts.BigTiff, box, boxsrs, size, opts.NoData)
+	w.opts = opts
+	return w.WriteData(out)
 }
 
-func NewTileWriter(src TileSource, enc binary.ByteOrder, bigtiff bool, box vec2d.Rect, boxsrs geo.Proj, size [2]uint32, noData *string) *TileWriter {
+func NewTileWriter(src TileSource, enc binary.ByteOrder, bigtiff bool, box vec2d.Rect, boxsrs geo.Proj, size [2]uint32, noData *float64) *TileWriter {
 	w := &TileWriter{src: src, boxsrs: boxsrs, size: size, box: box, ifd: &IFD{}, noData: noData, Writer: Writer{enc: enc, bigtiff: bigtiff}}
 	return w
 }
 
-func (l *TileWriter) setupIFD() {
-	l.ifd.SetEPSG(uint(4326), true)
+func (l *TileWriter) SetTransform(gt GeoTransform) {
+	l.transform = &gt
+}
+
+func (l *TileWriter) SetGCPs(gcps []GCP) {
+	l.gcps = gcps
+}
+
+// SetRPC writes the RPC model in place of a geotransform and geokeys, the
+// way RPC-only imagery is delivered.
+func (l *TileWriter) SetRPC(m *RPCModel) {
+	l.rpc = m
+}
+
+func (l *TileWriter) setupIFD() error {
+	if l.rpc == nil {
+		l.ifd.SetEPSG(uint(4326), true)
+	}
 	l.ifd.ImageWidth, l.ifd.ImageLength = uint64(l.size[0]), uint64(l.size[1])
 
 	if l.ifd.TileWidth != uint16(l.size[0]) {
@@ -58,23 +80,38 @@ func (l *TileWriter) setupIFD() {
 	if l.ifd.TileLength != uint16(l.size[1]) {
 		l.ifd.TileLength = uint16(l.size[1])
 	}
-	box := l.boxsrs.TransformRectTo(epsg4326, l.box, 16)
-
-	cellSizeX := (box.Max[0] - box.Min[0]) / float64(l.size[0])
-	cellSizeY := (box.Max[1] - box.Min[1]) / float64(l.size[1])
+	if l.rpc != nil {
+		l.ifd.SetRPCModel(l.rpc)
+	} else if len(l.gcps) > 0 {
+		l.ifd.SetGCPs(l.gcps)
+	} else if l.transform != nil {
+		l.ifd.SetGeoTransform(*l.transform)
+	} else {
+		box := l.boxsrs.TransformRectTo(epsg4326, l.box, 16)
+
+		cellSizeX := (box.Max[0] - box.Min[0]) / float64(l.size[0])
+		cellSizeY := (box.Max[1] - box.Min[1]) / float64(l.size[1])
+
+		l.ifd.SetGeoTransform(GeoTransform{box.Min[0], cellSizeX, 0, box.Max[1], 0, -cellSizeY})
+	}
 
-	l.ifd.ModelTiePointTag = []float64{0, 0, 0, box.Min[0], box.Max[1], 0}
-	l.ifd.ModelPixelScaleTag = []float64{cellSizeX, cellSizeY, 0}
+	l.ifd.SetNoData(l.noData)
 
-	if l.noData != nil {
-		l.ifd.NoData = *l.noData
-	}
+	return l.opts.applyIFD(l.ifd)
 }
 
 func (l *TileWriter) WriteData(out io.Writer) error {
+	if err := l.opts.checkTags(); err != nil {
+		return err
+	}
 	buf := &bytes.Buffer{}
 
-	l.setupIFD()
+	l.src = l.opts.quantize(l.src)
+	l.opts.applySource(l.src)
+	setSourceByteOrder(l.src, l.enc)
+	if err := l.setupIFD(); err != nil {
+		return err
+	}
 
 	ifd := l.ifd
 
@@ -90,11 +127,11 @@ func (l *TileWriter) WriteData(out io.Writer) error {
 		strileData.Offset = 8
 	}
 
-	strileData.Offset += uint64(len(ghost))
+	strileData.Offset += uint64(ghostSize)
 
 	strileData.Offset += l.ifd.tagsSize
 
-	glen := uint64(len(ghost))
+	glen := uint64(ghostSize)
 	l.writeHeader(out)
 
 	off := uint64(16 + glen)
@@ -107,9 +144,9 @@ func (l *TileWriter) WriteData(out io.Writer) error {
 		dataOffset = 8
 	}
 
-	dataOffset += uint64(len(ghost)) + 4
+	dataOffset += uint64(ghostSize) + 4
 
-	ifd.ntags, ifd.tagsSize, ifd.strileSize, ifd.nplanes = ifd.structure(false)
+	ifd.ntags, ifd.tagsSize, ifd.strileSize, ifd.nplanes = ifd.structure(l.bigtiff)
 
 	dataOffset += ifd.strileSize + ifd.tagsSize
 
@@ -126,7 +163,7 @@ func (l *TileWriter) WriteData(out io.Writer) error {
 		return fmt.Errorf("write strile pointers: %w", err)
 	}
 
-	_, err = out.Write(buf.Bytes())
+	err = writeBlock(out, l.enc, buf.Bytes())
 	if err != nil {
 		return fmt.Errorf("write data: %w", err)
 	}
@@ -1,9 +1,7 @@
 package cog
 
 import (
-	"encoding/binary"
 	"fmt"
-	"image"
 	"io"
 
 	vec2d "github.com/flywave/go3d/float64/vec2"
@@ -20,13 +18,20 @@ func minInt(a, b int) int {
 
 const (
 	TagNewSubfileType            = 254
+	TagSubfileType               = 255
 	TagImageWidth                = 256
 	TagImageLength               = 257
 	TagBitsPerSample             = 258
 	TagCompression               = 259
 	TagPhotometricInterpretation = 262
+	TagThreshholding             = 263
+	TagCellWidth                 = 264
+	TagCellLength                = 265
 	TagFillOrder                 = 266
 	TagDocumentName              = 269
+	TagImageDescription          = 270
+	TagMake                      = 271
+	TagModel                     = 272
 	TagPlanarConfiguration       = 284
 
 	TagStripOffsets    = 273
@@ -34,6 +39,8 @@ const (
 	TagSamplesPerPixel = 277
 	TagRowsPerStrip    = 278
 	TagStripByteCounts = 279
+	TagMinSampleValue  = 280
+	TagMaxSampleValue  = 281
 
 	TagTileWidth      = 322
 	TagTileLength     = 323
@@ -44,15 +51,25 @@ const (
 	TagYResolution    = 283
 	TagResolutionUnit = 296
 
+	TagGrayResponseUnit  = 290
+	TagGrayResponseCurve = 291
+
 	TagSoftware     = 305
 	TagDateTime     = 306
+	TagArtist       = 315
+	TagHostComputer = 316
 	TagPredictor    = 317
 	TagColorMap     = 320
+	TagInkSet       = 332
 	TagExtraSamples = 338
 	TagSampleFormat = 339
 
 	TagJPEGTables = 347
 
+	TagYCbCrSubSampling = 530
+
+	TagCopyright = 33432
+
 	TagGDAL_METADATA = 42112
 	TagGDAL_NODATA   = 42113
 
@@ -151,6 +168,16 @@ const (
 	SampleFormatComplexIEEEFP = 6
 )
 
+// ComplexInt16 and ComplexInt32 are the samples of CInt16 and CInt32
+// images, stored as a real and imaginary pair.
+type ComplexInt16 struct {
+	Real, Imag int16
+}
+
+type ComplexInt32 struct {
+	Real, Imag int32
+}
+
 type ExtraSamples uint16
 
 const (
@@ -209,6 +236,9 @@ const (
 	IRGB
 	IRGBA
 	INRGBA
+	ICMYK
+	IYCbCr
+	ICIELab
 )
 
 const (
@@ -287,76 +317,23 @@ func loadIFD(r tiff.BReader, tifd tiff.IFD) (*IFD, error) {
 		}
 		ifd.TempTileByteCounts = nil //reclaim mem
 	}
+	loadExtraTags(ifd, tifd)
 	return ifd, nil
 }
 
-func encodeGray(w io.Writer, pix []uint8, dx, dy, stride int) error {
-	return writePix(w, pix, dy, dx, stride)
-}
-
-func encodeGray16(w io.Writer, pix []uint8, dx, dy, stride int) error {
-	buf := make([]byte, dx*2)
+// packSamples writes one sample per byte of pix as bits wide values, most
+// significant bit first, starting each row on a byte boundary.
+func packSamples(w io.Writer, pix []uint8, dx, dy, stride, bits int) error {
+	buf := make([]byte, (dx*bits+7)/8)
+	mask := uint8(1)<<uint(bits) - 1
 	for y := 0; y < dy; y++ {
-		min := y*stride + 0
-		max := y*stride + dx*2
-		off := 0
-		for i := min; i < max; i += 2 {
-			v1 := uint16(pix[i])<<8 | uint16(pix[i+1])
-			buf[off+0] = byte(v1)
-			buf[off+1] = byte(v1 >> 8)
-			off += 2
-		}
-		if _, err := w.Write(buf); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func encodeRGBA(w io.Writer, pix []uint8, dx, dy, stride int) error {
-	return writePix(w, pix, dy, dx*4, stride)
-}
-
-func encodeRGBA64(w io.Writer, pix []uint8, dx, dy, stride int) error {
-	buf := make([]byte, dx*8)
-	for y := 0; y < dy; y++ {
-		min := y*stride + 0
-		max := y*stride + dx*8
-		off := 0
-		for i := min; i < max; i += 8 {
-			r1 := uint16(pix[i+0])<<8 | uint16(pix[i+1])
-			g1 := uint16(pix[i+2])<<8 | uint16(pix[i+3])
-			b1 := uint16(pix[i+4])<<8 | uint16(pix[i+5])
-			a1 := uint16(pix[i+6])<<8 | uint16(pix[i+7])
-			buf[off+0] = byte(r1)
-			buf[off+1] = byte(r1 >> 8)
-			buf[off+2] = byte(g1)
-			buf[off+3] = byte(g1 >> 8)
-			buf[off+4] = byte(b1)
-			buf[off+5] = byte(b1 >> 8)
-			buf[off+6] = byte(a1)
-			buf[off+7] = byte(a1 >> 8)
-			off += 8
-		}
-		if _, err := w.Write(buf); err != nil {
-			return err
+		for i := range buf {
+			buf[i] = 0
 		}
-	}
-	return nil
-}
-
-func encode(w io.Writer, m image.Image) error {
-	bounds := m.Bounds()
-	buf := make([]byte, 4*bounds.Dx())
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		off := 0
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := m.At(x, y).RGBA()
-			buf[off+0] = uint8(r >> 8)
-			buf[off+1] = uint8(g >> 8)
-			buf[off+2] = uint8(b >> 8)
-			buf[off+3] = uint8(a >> 8)
-			off += 4
+		row := pix[y*stride : y*stride+dx]
+		for x, v := range row {
+			shift := 8 - bits - (x*bits)%8
+			buf[x*bits/8] |= (v & mask) << uint(shift)
 		}
 		if _, err := w.Write(buf); err != nil {
 			return err
@@ -365,50 +342,20 @@ func encode(w io.Writer, m image.Image) error {
 	return nil
 }
 
-func encodeUInt16(w io.Writer, bounds image.Rectangle, enc binary.ByteOrder, m []uint16) error {
-	return binary.Write(w, enc, m)
-}
-
-func encodeUInt32(w io.Writer, bounds image.Rectangle, enc binary.ByteOrder, m []uint32) error {
-	return binary.Write(w, enc, m)
-}
-
-func encodeUInt64(w io.Writer, bounds image.Rectangle, enc binary.ByteOrder, m []uint64) error {
-	return binary.Write(w, enc, m)
-}
-
-func encodeInt16(w io.Writer, bounds image.Rectangle, enc binary.ByteOrder, m []int16) error {
-	return binary.Write(w, enc, m)
-}
-
-func encodeInt32(w io.Writer, bounds image.Rectangle, enc binary.ByteOrder, m []int32) error {
-	return binary.Write(w, enc, m)
-}
-
-func encodeInt64(w io.Writer, bounds image.Rectangle, enc binary.ByteOrder, m []int64) error {
-	return binary.Write(w, enc, m)
-}
-
-func encodeFloat32(w io.Writer, bounds image.Rectangle, enc binary.ByteOrder, m []float32) error {
-	return binary.Write(w, enc, m)
-}
-
-func encodeFloat64(w io.Writer, bounds image.Rectangle, enc binary.ByteOrder, m []float64) error {
-	return binary.Write(w, enc, m)
-}
-
-func writePix(w io.Writer, pix []byte, nrows, length, stride int) error {
-	if length == stride {
-		_, err := w.Write(pix[:nrows*length])
-		return err
-	}
-	for ; nrows > 0; nrows-- {
-		if _, err := w.Write(pix[:length]); err != nil {
-			return err
+// unpackSamples expands rows of packed bits wide samples to one byte each.
+func unpackSamples(buf []byte, bits, samples int) []byte {
+	rowLen := (samples*bits + 7) / 8
+	rows := len(buf) / rowLen
+	out := make([]byte, rows*samples)
+	mask := uint8(1)<<uint(bits) - 1
+	for y := 0; y < rows; y++ {
+		row := buf[y*rowLen : (y+1)*rowLen]
+		for x := 0; x < samples; x++ {
+			shift := 8 - bits - (x*bits)%8
+			out[y*samples+x] = row[x*bits/8] >> uint(shift) & mask
 		}
-		pix = pix[stride:]
 	}
-	return nil
+	return out
 }
 
 func sanityCheckIFD(ifd tiff.IFD) error {
@@ -0,0 +1,246 @@
+package cog
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/tiff"
+	_ "github.com/google/tiff/bigtiff"
+)
+
+const (
+	subfileReduced = 1
+	subfileMask    = 4
+)
+
+type ValidationIssue struct {
+	IFD     int
+	Message string
+}
+
+func (i ValidationIssue) String() string {
+	if i.IFD < 0 {
+		return i.Message
+	}
+	return fmt.Sprintf("ifd %d: %s", i.IFD, i.Message)
+}
+
+type ValidationReport struct {
+	Errors   []ValidationIssue
+	Warnings []ValidationIssue
+}
+
+func (r *ValidationReport) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *ValidationReport) errorf(ifd int, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, ValidationIssue{IFD: ifd, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *ValidationReport) warnf(ifd int, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, ValidationIssue{IFD: ifd, Message: fmt.Sprintf(format, args...)})
+}
+
+type validateLevel struct {
+	index  int
+	ifd    *IFD
+	offset uint64
+	mask   *validateLevel
+}
+
+func (l *validateLevel) blocks() ([]uint64, []uint32) {
+	if len(l.ifd.OriginalTileOffsets) > 0 {
+		return l.ifd.OriginalTileOffsets, l.ifd.TileByteCounts
+	}
+	offsets := make([]uint64, len(l.ifd.StripOffsets))
+	for i, off := range l.ifd.StripOffsets {
+		offsets[i] = uint64(off)
+	}
+	return offsets, l.ifd.StripByteCounts
+}
+
+func (l *validateLevel) firstBlock() uint64 {
+	offsets, _ := l.blocks()
+	for _, off := range offsets {
+		if off != 0 {
+			return off
+		}
+	}
+	return 0
+}
+
+func (l *validateLevel) tiled() bool {
+	return l.ifd.TileWidth > 0 && l.ifd.TileLength > 0
+}
+
+func Validate(r tiff.ReadAtReadSeeker) (*ValidationReport, error) {
+	_, bigtiff, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tif, err := tiff.Parse(r, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	report := &ValidationReport{}
+
+	headerSize := uint64(8)
+	if bigtiff {
+		headerSize = 16
+	}
+	ghost, ghostSize, err := readGhost(r)
+	if err != nil {
+		report.errorf(-1, "%v", err)
+	}
+	if ghost == nil {
+		report.warnf(-1, "missing GDAL_STRUCTURAL_METADATA ghost area")
+	} else {
+		if ghost["LAYOUT"] != "IFDS_BEFORE_DATA" {
+			report.errorf(-1, "ghost area LAYOUT should be IFDS_BEFORE_DATA, got %q", ghost["LAYOUT"])
+		}
+		if ghost["BLOCK_ORDER"] != "ROW_MAJOR" {
+			report.errorf(-1, "ghost area BLOCK_ORDER should be ROW_MAJOR, got %q", ghost["BLOCK_ORDER"])
+		}
+		if ghost["KNOWN_INCOMPATIBLE_EDITION"] == "YES" {
+			report.errorf(-1, "file was modified after creation and is no longer a valid COG")
+		}
+		if err := VerifyBlocks(r); err != nil {
+			report.errorf(-1, "%v", err)
+		}
+	}
+
+	tifds := tif.IFDs()
+	if len(tifds) == 0 {
+		report.errorf(-1, "no IFD found")
+		return report, nil
+	}
+
+	var images []*validateLevel
+	var offset uint64
+	for i, tifd := range tifds {
+		ifd, err := loadIFD(tif.R(), tifd)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			offset = firstIFDOffset(r, bigtiff)
+		} else {
+			offset = tifds[i-1].NextOffset()
+		}
+		l := &validateLevel{index: i, ifd: ifd, offset: offset}
+		if ifd.NewSubfileType&subfileMask != 0 {
+			if len(images) == 0 {
+				report.errorf(i, "mask IFD appears before any image IFD")
+				continue
+			}
+			parent := images[len(images)-1]
+			if parent.mask != nil {
+				report.errorf(i, "image IFD %d has more than one mask", parent.index)
+			} else if ifd.ImageWidth != parent.ifd.ImageWidth || ifd.ImageLength != parent.ifd.ImageLength {
+				report.errorf(i, "mask does not match the dimensions of the preceding image IFD %d", parent.index)
+			}
+			parent.mask = l
+			continue
+		}
+		if len(images) > 0 && ifd.NewSubfileType&subfileReduced == 0 {
+			report.warnf(i, "additional full resolution image is not an overview")
+		}
+		images = append(images, l)
+	}
+
+	main := images[0]
+	if main.offset != headerSize && main.offset != (headerSize+uint64(ghostSize)+1)&^1 {
+		report.errorf(main.index, "the main IFD should directly follow the header, found at offset %d", main.offset)
+	}
+	if !main.tiled() && (main.ifd.ImageWidth > 512 || main.ifd.ImageLength > 512) {
+		report.errorf(main.index, "the file is greater than 512x512 but is not tiled")
+	}
+	if len(images) == 1 && (main.ifd.ImageWidth > 512 || main.ifd.ImageLength > 512) {
+		report.warnf(-1, "the file is greater than 512x512, it is recommended to include internal overviews")
+	}
+
+	var levels []*validateLevel
+	for i, l := range images {
+		levels = append(levels, l)
+		if l.mask != nil {
+			levels = append(levels, l.mask)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := images[i-1]
+		if !l.tiled() {
+			report.errorf(l.index, "overview is not tiled")
+		}
+		if l.ifd.ImageWidth > prev.ifd.ImageWidth || l.ifd.ImageLength > prev.ifd.ImageLength {
+			report.errorf(l.index, "overview has larger dimensions than IFD %d", prev.index)
+		}
+	}
+
+	lastIFD, firstData := uint64(0), uint64(0)
+	for i, l := range levels {
+		if i > 0 && l.offset <= levels[i-1].offset {
+			report.errorf(l.index, "IFD offset %d should be greater than the one of IFD %d", l.offset, levels[i-1].index)
+		}
+		if l.offset > lastIFD {
+			lastIFD = l.offset
+		}
+		if l.tiled() && (l.ifd.TileWidth%16 != 0 || l.ifd.TileLength%16 != 0) {
+			report.errorf(l.index, "tile size %dx%d is not a multiple of 16", l.ifd.TileWidth, l.ifd.TileLength)
+		}
+
+		offsets, counts := l.blocks()
+		last := uint64(0)
+		for j, off := range offsets {
+			if off == 0 || j >= len(counts) || counts[j] == 0 {
+				continue
+			}
+			if off < last {
+				report.errorf(l.index, "block %d offset %d is lower than the previous block offset %d", j, off, last)
+			}
+			last = off
+			if firstData == 0 || off < firstData {
+				firstData = off
+			}
+		}
+	}
+	if firstData != 0 && lastIFD > firstData {
+		report.errorf(-1, "the last IFD at offset %d should be before the first data block at offset %d", lastIFD, firstData)
+	}
+
+	for i := len(images) - 1; i > 0; i-- {
+		cur, next := images[i-1].firstBlock(), images[i].firstBlock()
+		if cur != 0 && next != 0 && cur < next {
+			if i == 1 {
+				report.errorf(images[0].index, "the first block of the main image should be after the one of overview IFD %d", images[i].index)
+			} else {
+				report.errorf(images[i-1].index, "the first block of the overview should be after the one of overview IFD %d", images[i].index)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func firstIFDOffset(r tiff.ReadAtReadSeeker, bigtiff bool) uint64 {
+	enc, _, err := readHeader(r)
+	if err != nil {
+		return 0
+	}
+	if bigtiff {
+		var buf [8]byte
+		if _, err := r.ReadAt(buf[:], 8); err != nil {
+			return 0
+		}
+		return enc.Uint64(buf[:])
+	}
+	var buf [4]byte
+	if _, err := r.ReadAt(buf[:], 4); err != nil {
+		return 0
+	}
+	return uint64(enc.Uint32(buf[:]))
+}
@@ -0,0 +1,58 @@
+package cog
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"testing"
+)
+
+func TestValidateCorpus(t *testing.T) {
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{"cog_ext_multi.tif", true},
+		{"cog_bigtiff.tif", true},
+		{"tiled.tif", false},
+		{"test.tif", false},
+		{"scan_512x512_rgb8_tiled.tif", false},
+	}
+	for _, c := range cases {
+		f, err := os.Open("./test_data/" + c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := Validate(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Valid() != c.valid {
+			t.Fatalf("%s: valid=%v, errors %v", c.name, report.Valid(), report.Errors)
+		}
+	}
+}
+
+func TestValidateWrittenCOG(t *testing.T) {
+	rect := image.Rect(0, 0, 512, 512)
+	for _, spool := range []SpoolFactory{nil, MemorySpool()} {
+		base := buildTestLayer(t)
+		ov := NewTileLayer(base.box, 13, base.grid)
+		for _, tile := range ov.tiles {
+			tile.Src = NewSource(makePixelData(base.tiles[0].Src.Data(), 512, 512), &rect, CTLZW)
+		}
+
+		buf := &bytes.Buffer{}
+		if err := WriteTo(buf, []*TileLayer{ov, base}, &Options{Spool: spool}); err != nil {
+			t.Fatal(err)
+		}
+		report, err := Validate(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Valid() || len(report.Warnings) != 0 {
+			t.Fatalf("errors %v warnings %v", report.Errors, report.Warnings)
+		}
+	}
+}
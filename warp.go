@@ -0,0 +1,430 @@
+package cog
+
+import (
+	"errors"
+	"image"
+	"math"
+
+	"github.com/flywave/go-geo"
+
+	vec2d "github.com/flywave/go3d/float64/vec2"
+)
+
+type ResampleMethod int
+
+const (
+	ResampleNearest ResampleMethod = iota
+	ResampleBilinear
+	ResampleCubic
+)
+
+var ResampleMethodMap = map[string]ResampleMethod{
+	"nearest":  ResampleNearest,
+	"bilinear": ResampleBilinear,
+	"cubic":    ResampleCubic,
+}
+
+type WarpSource interface {
+	Bounds() image.Rectangle
+	ReadWindow(rect image.Rectangle) (*Raster, error)
+}
+
+type memorySource struct {
+	data *Raster
+	err  error
+	rect image.Rectangle
+}
+
+// NewMemorySource serves windows of data, which is converted to a Raster
+// like in NewSource.
+func NewMemorySource(data interface{}, rect image.Rectangle) WarpSource {
+	r, err := toRaster(data, rect.Dx(), rect.Dy(), false)
+	return &memorySource{data: r, err: err, rect: rect}
+}
+
+func (s *memorySource) Bounds() image.Rectangle {
+	return s.rect
+}
+
+func (s *memorySource) ReadWindow(rect image.Rectangle) (*Raster, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if rect == s.rect {
+		return s.data, nil
+	}
+	return cropPixelData(s.data, s.rect, rect)
+}
+
+type layerSource struct {
+	layer *TileLayer
+}
+
+func (s *layerSource) Bounds() image.Rectangle {
+	return image.Rect(0, 0, s.layer.size[0], s.layer.size[1])
+}
+
+func (s *layerSource) ReadWindow(rect image.Rectangle) (*Raster, error) {
+	var like *Raster
+	for _, t := range s.layer.tiles {
+		if t.Src != nil && t.Src.Data() != nil {
+			like = t.Src.Data()
+			break
+		}
+	}
+	if like == nil {
+		return nil, errors.New("layer has no tile data")
+	}
+	out := makePixelData(like, rect.Dx(), rect.Dy())
+	if s.layer.noData != nil && *s.layer.noData != 0 {
+		if err := fillPixelData(out, rect.Dx(), rect.Dy(), *s.layer.noData); err != nil {
+			return nil, err
+		}
+	}
+	dst, err := newPixelBuffer(out, rect.Dx(), rect.Dy())
+	if err != nil {
+		return nil, err
+	}
+	tw, th := int(s.layer.grid.TileSize[0]), int(s.layer.grid.TileSize[1])
+	for _, t := range s.layer.tiles {
+		tr := image.Rect(t.block[0]*tw, t.block[1]*th, (t.block[0]+1)*tw, (t.block[1]+1)*th)
+		in := tr.Intersect(rect)
+		if in.Empty() || t.Src == nil || t.Src.Data() == nil {
+			continue
+		}
+		src, err := newPixelBuffer(t.Src.Data(), tw, th)
+		if err != nil {
+			return nil, err
+		}
+		for y := in.Min.Y; y < in.Max.Y; y++ {
+			for x := in.Min.X; x < in.Max.X; x++ {
+				for b := 0; b < src.bands; b++ {
+					dst.put(x-rect.Min.X, y-rect.Min.Y, b, src.at(x-tr.Min.X, y-tr.Min.Y, b))
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+type Warper struct {
+	src            WarpSource
+	transform      PixelTransformer
+	srs            geo.Proj
+	resampling     ResampleMethod
+	errorThreshold float64
+	noData         *float64
+}
+
+func NewWarper(src WarpSource, transform PixelTransformer, srs geo.Proj, resampling ResampleMethod) *Warper {
+	return &Warper{src: src, transform: transform, srs: srs, resampling: resampling, errorThreshold: 0.125}
+}
+
+func NewReaderWarper(r *Reader, index int, resampling ResampleMethod) (*Warper, error) {
+	if index < 0 || index >= len(r.Data) || r.Data[index] == nil {
+		return nil, errors.New("image not loaded")
+	}
+	tr, err := r.GetPixelTransformer(index)
+	if err != nil {
+		return nil, err
+	}
+	srs := epsg4326
+	if _, ok := tr.(*RPCTransform); !ok {
+		code, err := r.GetEPSGCode(index)
+		if err != nil {
+			return nil, err
+		}
+		if code == 0 {
+			return nil, errors.New("image has no EPSG code")
+		}
+		srs = geo.NewProj(code)
+	}
+	size := r.GetSize(index)
+	src := NewMemorySource(r.Data[index], image.Rect(0, 0, int(size[0]), int(size[1])))
+	w := NewWarper(src, tr, srs, resampling)
+	w.noData = r.GetNoData(index)
+	return w, nil
+}
+
+// NewLayerWarper resamples the tiles already set on l, in its grid's
+// coordinates. Warping the next level down from it halves the resolution in
+// one step, so overviews are built level by level instead of each being
+// decimated from the full resolution input.
+func NewLayerWarper(l *TileLayer, resampling ResampleMethod) *Warper {
+	res := [2]float64{
+		(l.box.Max[0] - l.box.Min[0]) / float64(l.size[0]),
+		(l.box.Max[1] - l.box.Min[1]) / float64(l.size[1]),
+	}
+	gt := GeoTransform{l.box.Min[0], res[0], 0, l.box.Max[1], 0, -res[1]}
+	w := NewWarper(&layerSource{layer: l}, gt, l.grid.Srs, resampling)
+	w.noData = l.noData
+	return w
+}
+
+func (w *Warper) SetErrorThreshold(pixels float64) {
+	w.errorThreshold = pixels
+}
+
+func (w *Warper) SetNoData(v *float64) {
+	w.noData = v
+}
+
+func (w *Warper) Extent(srs geo.Proj) vec2d.Rect {
+	b := w.src.Bounds()
+	var box vec2d.Rect
+	if gt, ok := w.transform.(GeoTransform); ok {
+		box = gt.Bounds(b.Dx(), b.Dy())
+	} else {
+		box = vec2d.Rect{Min: vec2d.MaxVal, Max: vec2d.MinVal}
+		const steps = 16
+		for i := 0; i <= steps; i++ {
+			fx := float64(b.Min.X) + float64(b.Dx())*float64(i)/steps
+			fy := float64(b.Min.Y) + float64(b.Dy())*float64(i)/steps
+			for _, p := range [4][2]float64{{fx, float64(b.Min.Y)}, {fx, float64(b.Max.Y)}, {float64(b.Min.X), fy}, {float64(b.Max.X), fy}} {
+				x, y := w.transform.PixelToWorld(p[0], p[1])
+				box.Extend(&vec2d.T{x, y})
+			}
+		}
+	}
+	if srs == nil || srs.Eq(w.srs) {
+		return box
+	}
+	return w.srs.TransformRectTo(srs, box, 16)
+}
+
+func (w *Warper) pixelTransformer(dstGT GeoTransform, dstSrs geo.Proj) (func(xs, ys []float64), error) {
+	toPixel := w.transform.WorldToPixel
+	if gt, ok := w.transform.(GeoTransform); ok {
+		inv, err := gt.Inverse()
+		if err != nil {
+			return nil, err
+		}
+		toPixel = inv.PixelToWorld
+	}
+	reproject := dstSrs != nil && w.srs != nil && !dstSrs.Eq(w.srs)
+	return func(xs, ys []float64) {
+		pts := make([]vec2d.T, len(xs))
+		for i := range xs {
+			x, y := dstGT.PixelToWorld(xs[i], ys[i])
+			pts[i] = vec2d.T{x, y}
+		}
+		if reproject {
+			pts = dstSrs.TransformTo(w.srs, pts)
+		}
+		for i := range pts {
+			xs[i], ys[i] = toPixel(pts[i][0], pts[i][1])
+		}
+	}, nil
+}
+
+func approxTransform(exact func(xs, ys []float64), threshold float64, inX, inY, outX, outY []float64) {
+	n := len(inX)
+	if threshold <= 0 || n < 5 {
+		copy(outX, inX)
+		copy(outY, inY)
+		exact(outX, outY)
+		return
+	}
+	mid := n / 2
+	px := []float64{inX[0], inX[mid], inX[n-1]}
+	py := []float64{inY[0], inY[mid], inY[n-1]}
+	exact(px, py)
+
+	t := float64(mid) / float64(n-1)
+	ex := px[0] + (px[2]-px[0])*t
+	ey := py[0] + (py[2]-py[0])*t
+	if math.Abs(ex-px[1])+math.Abs(ey-py[1]) <= threshold {
+		for i := 0; i < n; i++ {
+			t := float64(i) / float64(n-1)
+			outX[i] = px[0] + (px[2]-px[0])*t
+			outY[i] = py[0] + (py[2]-py[0])*t
+		}
+		return
+	}
+	approxTransform(exact, threshold, inX[:mid+1], inY[:mid+1], outX[:mid+1], outY[:mid+1])
+	approxTransform(exact, threshold, inX[mid:], inY[mid:], outX[mid:], outY[mid:])
+}
+
+func (w *Warper) Warp(box vec2d.Rect, srs geo.Proj, size [2]int, ctype CompressionType) (TileSource, error) {
+	data, err := w.warpData(box, srs, size)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	rect := image.Rect(0, 0, size[0], size[1])
+	return NewSource(data, &rect, ctype), nil
+}
+
+func (w *Warper) WarpLayer(l *TileLayer, ctype CompressionType) error {
+	size := [2]int{int(l.grid.TileSize[0]), int(l.grid.TileSize[1])}
+	for _, t := range l.tiles {
+		box := l.grid.TileBBox(t.Id, false)
+		src, err := w.Warp(box, l.grid.Srs, size, ctype)
+		if err != nil {
+			return err
+		}
+		if src != nil {
+			t.Src = src
+		}
+	}
+	return nil
+}
+
+func (w *Warper) warpData(box vec2d.Rect, srs geo.Proj, size [2]int) (*Raster, error) {
+	width, height := size[0], size[1]
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid warp size")
+	}
+	dstGT := GeoTransform{
+		box.Min[0], (box.Max[0] - box.Min[0]) / float64(width), 0,
+		box.Max[1], 0, -(box.Max[1] - box.Min[1]) / float64(height),
+	}
+	exact, err := w.pixelTransformer(dstGT, srs)
+	if err != nil {
+		return nil, err
+	}
+
+	n := width * height
+	sx := make([]float64, n)
+	sy := make([]float64, n)
+	inX := make([]float64, width)
+	inY := make([]float64, width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			inX[x] = float64(x) + 0.5
+			inY[x] = float64(y) + 0.5
+		}
+		approxTransform(exact, w.errorThreshold, inX, inY, sx[y*width:(y+1)*width], sy[y*width:(y+1)*width])
+	}
+
+	bounds := w.src.Bounds()
+	minx, miny := math.Inf(1), math.Inf(1)
+	maxx, maxy := math.Inf(-1), math.Inf(-1)
+	for i := 0; i < n; i++ {
+		if math.IsNaN(sx[i]) || math.IsNaN(sy[i]) {
+			continue
+		}
+		minx, maxx = math.Min(minx, sx[i]), math.Max(maxx, sx[i])
+		miny, maxy = math.Min(miny, sy[i]), math.Max(maxy, sy[i])
+	}
+	if math.IsInf(minx, 1) {
+		return nil, nil
+	}
+	window := image.Rect(
+		int(math.Floor(minx))-2, int(math.Floor(miny))-2,
+		int(math.Ceil(maxx))+2, int(math.Ceil(maxy))+2,
+	).Intersect(bounds)
+	if window.Empty() {
+		return nil, nil
+	}
+
+	wdata, err := w.src.ReadWindow(window)
+	if err != nil {
+		return nil, err
+	}
+	src, err := newPixelBuffer(wdata, window.Dx(), window.Dy())
+	if err != nil {
+		return nil, err
+	}
+	out := makePixelData(wdata, width, height)
+	dst, err := newPixelBuffer(out, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	fill := 0.0
+	if w.noData != nil {
+		fill = *w.noData
+	}
+	resampling := w.resampling
+	if wdata.Color == ColorPaletted {
+		resampling = ResampleNearest
+	}
+	ox, oy := float64(window.Min.X), float64(window.Min.Y)
+	for i := 0; i < n; i++ {
+		x, y := sx[i]-ox, sy[i]-oy
+		for b := 0; b < src.bands; b++ {
+			v, ok := w.sample(src, resampling, x, y, b)
+			if !ok {
+				v = fill
+			}
+			dst.set(i, b, v)
+		}
+	}
+	return out, nil
+}
+
+func (w *Warper) valid(src *pixelBuffer, x, y, b int) (float64, bool) {
+	if x < 0 || y < 0 || x >= src.width || y >= src.height {
+		return 0, false
+	}
+	v := src.at(x, y, b)
+	if isNoData(w.noData, v) {
+		return 0, false
+	}
+	return v, true
+}
+
+func (w *Warper) sample(src *pixelBuffer, method ResampleMethod, x, y float64, b int) (float64, bool) {
+	if math.IsNaN(x) || math.IsNaN(y) {
+		return 0, false
+	}
+	switch method {
+	case ResampleBilinear:
+		return w.sampleKernel(src, x, y, b, 1, bilinearWeight)
+	case ResampleCubic:
+		return w.sampleKernel(src, x, y, b, 2, cubicWeight)
+	default:
+		return w.valid(src, int(math.Floor(x)), int(math.Floor(y)), b)
+	}
+}
+
+func bilinearWeight(d float64) float64 {
+	d = math.Abs(d)
+	if d >= 1 {
+		return 0
+	}
+	return 1 - d
+}
+
+func cubicWeight(d float64) float64 {
+	const a = -0.5
+	d = math.Abs(d)
+	switch {
+	case d <= 1:
+		return (a+2)*d*d*d - (a+3)*d*d + 1
+	case d < 2:
+		return a*d*d*d - 5*a*d*d + 8*a*d - 4*a
+	}
+	return 0
+}
+
+func (w *Warper) sampleKernel(src *pixelBuffer, x, y float64, b int, radius int, kernel func(float64) float64) (float64, bool) {
+	cx, cy := x-0.5, y-0.5
+	x0, y0 := int(math.Floor(cx)), int(math.Floor(cy))
+	if _, ok := w.valid(src, int(math.Floor(x)), int(math.Floor(y)), b); !ok {
+		return 0, false
+	}
+	var sum, wsum float64
+	for j := y0 - radius + 1; j <= y0+radius; j++ {
+		wy := kernel(cy - float64(j))
+		if wy == 0 {
+			continue
+		}
+		for i := x0 - radius + 1; i <= x0+radius; i++ {
+			wx := kernel(cx - float64(i))
+			if wx == 0 {
+				continue
+			}
+			v, ok := w.valid(src, i, j, b)
+			if !ok {
+				continue
+			}
+			sum += v * wx * wy
+			wsum += wx * wy
+		}
+	}
+	if wsum == 0 {
+		return 0, false
+	}
+	return sum / wsum, true
+}
@@ -0,0 +1,113 @@
+package cog
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/flywave/go-geo"
+
+	vec2d "github.com/flywave/go3d/float64/vec2"
+)
+
+func TestWarpIdentity(t *testing.T) {
+	data := make([]float32, 64*64)
+	for i := range data {
+		data[i] = float32(i % 64)
+	}
+	srs := geo.NewProj(4326)
+	gt := GeoTransform{100, 0.01, 0, 30, 0, -0.01}
+	w := NewWarper(NewMemorySource(data, image.Rect(0, 0, 64, 64)), gt, srs, ResampleNearest)
+
+	box := vec2d.Rect{Min: vec2d.T{100, 29.36}, Max: vec2d.T{100.64, 30}}
+	src, err := w.Warp(box, srs, [2]int{64, 64}, CTNone)
+	if err != nil || src == nil {
+		t.FailNow()
+	}
+	d, _ := src.Data().Data()
+	out := d.([]float32)
+	for i := range out {
+		if out[i] != data[i] {
+			t.Fatalf("pixel %d: got %v want %v", i, out[i], data[i])
+		}
+	}
+}
+
+func TestWarpLayer(t *testing.T) {
+	data := make([]float32, 256*256)
+	for i := range data {
+		data[i] = 1
+	}
+	srs4326 := geo.NewProj(4326)
+	gt := GeoTransform{116.3, 0.001, 0, 40.0, 0, -0.001}
+	w := NewWarper(NewMemorySource(data, image.Rect(0, 0, 256, 256)), gt, srs4326, ResampleBilinear)
+	nodata := math.NaN()
+	w.SetNoData(&nodata)
+
+	conf := geo.DefaultTileGridOptions()
+	conf[geo.TILEGRID_SRS] = geo.NewProj(900913)
+	conf[geo.TILEGRID_TILE_SIZE] = []uint32{256, 256}
+	conf[geo.TILEGRID_ORIGIN] = geo.ORIGIN_UL
+	grid := geo.NewTileGrid(conf)
+
+	layer := NewTileLayer(w.Extent(grid.Srs), 12, grid)
+	if layer == nil {
+		t.FailNow()
+	}
+	defer layer.Close()
+
+	if err := w.WarpLayer(layer, CTLZW); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := 0
+	for _, tile := range layer.tiles {
+		if tile.Src == nil {
+			continue
+		}
+		d, _ := tile.Src.Data().Data()
+		for _, v := range d.([]float32) {
+			if v == 1 {
+				valid++
+			} else if !math.IsNaN(float64(v)) {
+				t.Fatalf("unexpected value %v", v)
+			}
+		}
+	}
+	if valid == 0 {
+		t.FailNow()
+	}
+}
+
+func TestLayerWarper(t *testing.T) {
+	conf := geo.DefaultTileGridOptions()
+	conf[geo.TILEGRID_SRS] = geo.NewProj(900913)
+	conf[geo.TILEGRID_TILE_SIZE] = []uint32{16, 16}
+	conf[geo.TILEGRID_ORIGIN] = geo.ORIGIN_UL
+	grid := geo.NewTileGrid(conf)
+
+	bbox := grid.TileBBox([3]int{8, 8, 4}, false)
+	parent := NewTileLayer(bbox, 5, grid)
+	rect := image.Rect(0, 0, 16, 16)
+	for _, tile := range parent.tiles {
+		data := make([]float32, 16*16)
+		for i := range data {
+			x, y := tile.block[0]*16+i%16, tile.block[1]*16+i/16
+			data[i] = float32(x + 100*y)
+		}
+		tile.Src = NewSource(data, &rect, CTNone)
+	}
+
+	child := NewTileLayer(bbox, 4, grid)
+	if err := NewLayerWarper(parent, ResampleBilinear).WarpLayer(child, CTNone); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := child.tiles[0].Src.Data().Data()
+	out := d.([]float32)
+	for i, v := range out {
+		x, y := i%16, i/16
+		if want := float32(2*x) + 0.5 + 100*(float32(2*y)+0.5); math.Abs(float64(v-want)) > 1e-3 {
+			t.Fatalf("pixel %d,%d: got %v want %v", x, y, v, want)
+		}
+	}
+}